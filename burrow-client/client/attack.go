@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/burrow/execution/exec"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+	"github.com/hyperledger/burrow/txs/payload"
+	log "github.com/sirupsen/logrus"
+)
+
+// moveAttack holds what a move replays to check the destination rejects it:
+// the proofs of the contract before it was locked and the header of them
+type moveAttack struct {
+	staleProofs *rpcquery.AccountProofs
+	staleHeader chan *payload.CallTx
+	// Sent the attacks before move2, once per move
+	sent bool
+}
+
+// prepareAttack reads the proofs of m before moveTo, nil if they cannot be read
+func (c *Client) prepareAttack(m *Move) *moveAttack {
+	proofs, err := c.clientConn[m.From].GetAccountProof(m.Contract)
+	if err != nil {
+		log.Warnf("[Client %v] No stale proofs to attack move %v: %v", c.id, m.ID, err)
+		return nil
+	}
+	return &moveAttack{
+		staleProofs: proofs,
+		staleHeader: c.requestSignedHeader(m.From, proofs.StorageProof.Version),
+	}
+}
+
+// Codes of the gRPC errors of txs the shard may not have answered
+var unansweredCodes = []string{"Unavailable", "DeadlineExceeded", "Canceled", "ResourceExhausted"}
+
+// attackResult is rejected if CheckTx or the execution of the attack failed,
+// accepted if it was executed and unknown if the shard did not answer
+func attackResult(ex *exec.TxExecution, err error) string {
+	if err == nil {
+		if ex == nil {
+			return "unknown"
+		}
+		if ex.Exception != nil {
+			return "rejected"
+		}
+		return "accepted"
+	}
+	// CheckTx errors are answered by the shard as gRPC errors, timeouts and
+	// network errors are local or have these codes
+	message := err.Error()
+	if !strings.HasPrefix(message, "rpc error: code = ") {
+		return "unknown"
+	}
+	for _, code := range unansweredCodes {
+		if strings.HasPrefix(message, "rpc error: code = "+code+" ") {
+			return "unknown"
+		}
+	}
+	return "rejected"
+}
+
+// sendAttack sends a move2 of the contract of m to shard that should be
+// rejected and records whether it was
+func (c *Client) sendAttack(kind string, m *Move, shard string, header *rpcquery.SignedHeader, proofs *rpcquery.AccountProofs) {
+	contract := m.Contract
+	move2Tx := &payload.CallTx{
+		Input: &payload.TxInput{
+			Amount: 1,
+		},
+		Address:      &contract,
+		Fee:          1,
+		GasLimit:     4100000000,
+		SignedHeader: header,
+		StorageProof: &proofs.StorageProof,
+		AccountProof: &proofs.AccountProof,
+	}
+	env, err := c.signMove(move2Tx, shard)
+	if err != nil {
+		log.Fatalf("Error signing %v attack: %v", kind, err)
+	}
+	var height uint64
+	ex, err := c.clientConn[shard].BroadcastEnvelope(env, c.scalableCoin.logger)
+	result := attackResult(ex, err)
+	if err != nil {
+		// Not in a block, the sequence is not used
+		c.resyncSequence(shard)
+	} else if ex != nil {
+		height = ex.Height
+	}
+	switch result {
+	case "accepted":
+		log.Warnf("[Client %v] %v move2 of %v accepted by %v at %v, move %v", c.id, kind, contract, shard, height, m.ID)
+	case "unknown":
+		log.Warnf("[Client %v] No answer to %v move2 of %v from %v, move %v: %v", c.id, kind, contract, shard, m.ID, err)
+	}
+	c.logs.Log("move-attacks", "%v %v %v %v %v %v %d %v\n", c.id, m.ID, kind, contract, shard, header.Height, height, result)
+}
+
+// attackBeforeMove2 sends the move2 of m with stale proofs, with a stale
+// header and to a wrong shard, before the honest move2 with proofs
+func (c *Client) attackBeforeMove2(m *Move, header *rpcquery.SignedHeader, proofs *rpcquery.AccountProofs) {
+	attack := m.attack
+	if attack == nil || attack.sent {
+		return
+	}
+	attack.sent = true
+
+	select {
+	case <-time.After(3 * time.Minute):
+		log.Warnf("[Client %v] Timeout while getting stale header of move %v", c.id, m.ID)
+	case staleTx := <-attack.staleHeader:
+		if staleTx == nil {
+			log.Warnf("[Client %v] No stale header to attack move %v", c.id, m.ID)
+			break
+		}
+		// Proofs of the contract before it was locked
+		c.sendAttack("staleProof", m, m.To, staleTx.SignedHeader, attack.staleProofs)
+		// Proofs of the locked contract with a header of another height
+		c.sendAttack("staleHeader", m, m.To, staleTx.SignedHeader, proofs)
+	}
+
+	// The contract is locked to move to m.To only
+	nPartitions := int(c.scalableCoin.NumberPartitions())
+	for partition := 1; partition <= nPartitions; partition++ {
+		shard := strconv.Itoa(partition)
+		if shard != m.From && shard != m.To {
+			c.sendAttack("wrongShard", m, shard, header, proofs)
+			return
+		}
+	}
+	log.Infof("[Client %v] No wrongShard attack on move %v, no shard other than %v and %v", c.id, m.ID, m.From, m.To)
+	c.logs.Log("move-attacks", "%v %v %v %v %v %v %d %v\n", c.id, m.ID, "wrongShard", m.Contract, "-", header.Height, 0, "skipped")
+}
+
+// attackAfterMove2 sends the committed move2 of m again
+func (c *Client) attackAfterMove2(m *Move, header *rpcquery.SignedHeader, proofs *rpcquery.AccountProofs) {
+	if m.attack == nil {
+		return
+	}
+	c.sendAttack("replay", m, m.To, header, proofs)
+	m.attack = nil
+}
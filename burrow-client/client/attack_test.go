@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/burrow/execution/exec"
+)
+
+func TestAttackResult(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ex     *exec.TxExecution
+		err    error
+		result string
+	}{
+		{"executed", &exec.TxExecution{Height: 3}, nil, "accepted"},
+		{"no execution", nil, nil, "unknown"},
+		{"checkTx", nil, errors.New("rpc error: code = Unknown desc = error code: insufficient funds"), "rejected"},
+		{"unavailable", nil, errors.New("rpc error: code = Unavailable desc = transport is closing"), "unknown"},
+		{"deadline", nil, errors.New("rpc error: code = DeadlineExceeded desc = context deadline exceeded"), "unknown"},
+		{"local timeout", nil, context.DeadlineExceeded, "unknown"},
+	} {
+		if result := attackResult(tc.ex, tc.err); result != tc.result {
+			t.Errorf("%v: %v, expected %v", tc.name, result, tc.result)
+		}
+	}
+}
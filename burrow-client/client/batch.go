@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/execution/exec"
+	"github.com/hyperledger/burrow/txs"
+	"github.com/hyperledger/burrow/txs/payload"
+	log "github.com/sirupsen/logrus"
+)
+
+// Times the proofs of a batch are fetched again to read them at one height
+const batchProofTries = 3
+
+// sendBatch sends envs to partition back to back, waiting only for the last
+// one. Sequences are consecutive, the others are executed before or with it.
+func (c *Client) sendBatch(partition string, envs []*txs.Envelope) (*exec.TxExecution, error) {
+	cli := c.clientConn[partition]
+	for _, env := range envs[:len(envs)-1] {
+		_, err := cli.BroadcastEnvelopeAsync(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cli.BroadcastEnvelope(envs[len(envs)-1], c.scalableCoin.logger)
+}
+
+func (c *Client) signMove(tx *payload.CallTx, partition string) (*txs.Envelope, error) {
+	c.sequencePerPartition[partition]++
+	tx.Input.Address = c.myAddress
+	tx.Input.Sequence = c.sequencePerPartition[partition]
+	env := txs.Enclose(partition, payload.Payload(tx))
+	return env, env.Sign(c.acc)
+}
+
+// broadcastMoveBatch moves contracts from shard from to shard to in one round
+// trip: the moveTo txs are sent together to be in one block, the proofs are
+// read at one height and every move2 carries the same signed header. Once a
+// moveTo may be committed, moves the batch cannot finish go on one by one.
+func (c *Client) broadcastMoveBatch(contracts []crypto.Address, from, to string) error {
+	startTime := time.Now()
+	moved := 0
+	defer func() {
+		c.logs.Log("move-latencies", "%v batched %d %v %v %d %d %v\n", c.id, len(contracts), from, to, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), moved == len(contracts))
+	}()
+	if from == to {
+		log.Fatalf("Cannot move to itself: %v, from: %v to: %v", contracts, from, to)
+	}
+	toInt, err := strconv.Atoi(to)
+	if err != nil {
+		return err
+	}
+
+	var moves []*Move
+	var envs []*txs.Envelope
+	for _, contract := range contracts {
+		moves = append(moves, c.newMove(contract, from, to))
+		env, err := c.signMove(c.scalableCoin.createMoveTo(contract, toInt), from)
+		if err != nil {
+			// Nothing sent
+			c.resyncSequence(from)
+			for _, m := range moves {
+				c.moveTransition(m, MoveFailed, 0)
+			}
+			return err
+		}
+		envs = append(envs, env)
+	}
+	ex, err := c.sendBatch(from, envs)
+	if err != nil {
+		// Some moveTo txs may be committed
+		c.resyncSequence(from)
+		moved, err = c.finishSingly(moves, err)
+		return err
+	}
+	c.logs.Log("latencies", "%v moveTo %v %v %v\n", c.id, from, ex.Height, true)
+	for _, m := range moves {
+		c.moveTransition(m, MoveToCommitted, int64(ex.Height))
+	}
+
+	moved, err = c.finishBatch(moves, from, to)
+	if err != nil && err != errMoveAborted {
+		var left []*Move
+		for _, m := range moves {
+			if !m.Finished() {
+				left = append(left, m)
+			}
+		}
+		var singly int
+		singly, err = c.finishSingly(left, err)
+		moved += singly
+	}
+	return err
+}
+
+// finishBatch sends the move2 txs of the moves, with their moveTo committed,
+// returning how many were committed. Past their deadline the moves are aborted.
+func (c *Client) finishBatch(moves []*Move, from, to string) (int, error) {
+	toInt, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, err
+	}
+	deadline := c.newMoveDeadline(moves[0])
+	var contracts []crypto.Address
+	for _, m := range moves {
+		contracts = append(contracts, m.Contract)
+	}
+	proofs, heights, err := utils.ProofsAtOneHeight(c.clientConn[from], contracts, batchProofTries)
+	if err != nil {
+		return 0, err
+	}
+	for height := range heights {
+		if deadline.expired(height) {
+			return c.abortBatch(moves)
+		}
+	}
+	// All requested before waiting, any of them may come first
+	headerChs := make(map[int64]chan *payload.CallTx)
+	for height := range heights {
+		headerChs[height] = c.requestSignedHeader(from, height)
+	}
+	for i, m := range moves {
+		c.moveTransition(m, ProofFetched, proofs[i].StorageProof.Version)
+		storageSize, proofSize := utils.ProofSizes(&proofs[i].StorageProof, &proofs[i].AccountProof)
+		c.scalableCoin.costModel.Measure(m.Contract, storageSize, proofSize)
+	}
+	headers := make(map[int64]*payload.CallTx)
+	for height, headerCh := range headerChs {
+		select {
+		case <-time.After(deadline.timeout(signedHeaderTimeout)):
+			if deadline.expired(height) {
+				return c.abortBatch(moves)
+			}
+			return 0, fmt.Errorf("Timeout while getting signed header %v of %v", height, from)
+		case headers[height] = <-headerCh:
+			if headers[height] == nil {
+				return 0, fmt.Errorf("Signed header %v of %v not fetched", height, from)
+			}
+		}
+	}
+
+	// Checked before signing any move2
+	for i := range moves {
+		err := c.scalableCoin.lightClient.VerifyProofs(headers[proofs[i].StorageProof.Version].SignedHeader, &proofs[i].AccountProof, &proofs[i].StorageProof)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var envs []*txs.Envelope
+	for i, m := range moves {
+		height := proofs[i].StorageProof.Version
+		c.moveTransition(m, HeaderObtained, height)
+		contract := m.Contract
+		move2Tx := &payload.CallTx{
+			Input: &payload.TxInput{
+				Amount: 1,
+			},
+			Address:      &contract,
+			Fee:          1,
+			GasLimit:     4100000000,
+			SignedHeader: headers[height].SignedHeader,
+			StorageProof: &proofs[i].StorageProof,
+			AccountProof: &proofs[i].AccountProof,
+		}
+		env, err := c.signMove(move2Tx, to)
+		if err != nil {
+			c.resyncSequence(to)
+			return 0, err
+		}
+		envs = append(envs, env)
+	}
+	ex, err := c.sendBatch(to, envs)
+	if err != nil {
+		c.resyncSequence(to)
+		return 0, err
+	}
+	c.logs.Log("latencies", "%v move2 %v %v\n", c.id, to, ex.Height)
+
+	// Only the execution of the last move2 is known, the contracts are looked up
+	moved := 0
+	for _, m := range moves {
+		acc, err := c.clientConn[to].GetAccount(m.Contract)
+		if err != nil || acc == nil || len(acc.Code) == 0 {
+			log.Warnf("[Client %v] move2 of %v to %v not committed", c.id, m.Contract, to)
+			continue
+		}
+		c.moveTransition(m, Move2Committed, int64(ex.Height))
+		c.scalableCoin.partitioning.Move(m.Contract, int64(toInt))
+		c.tokenToPartition[m.Contract] = int64(toInt)
+		moved++
+		c.moveTransition(m, MoveCompleted, int64(ex.Height))
+	}
+	if moved != len(moves) {
+		c.resyncSequence(to)
+		return moved, fmt.Errorf("%v of %v move2 txs not committed", len(moves)-moved, len(moves))
+	}
+	return moved, nil
+}
+
+// abortBatch aborts the moves of a batch past their deadline, returning how
+// many had their move2 committed after all
+func (c *Client) abortBatch(moves []*Move) (int, error) {
+	moved := 0
+	var err error
+	for _, m := range moves {
+		abortErr := c.abortMove(m)
+		if abortErr == nil {
+			moved++
+		} else {
+			err = abortErr
+		}
+	}
+	return moved, err
+}
+
+// finishSingly finishes the moves a batch left, after cause, as single moves
+// with their retries and deadline. Moves without their moveTo committed fail.
+func (c *Client) finishSingly(moves []*Move, cause error) (int, error) {
+	log.Warnf("[Client %v] Finishing %v batched moves one by one: %v", c.id, len(moves), cause)
+	moved := 0
+	var err error
+	for _, m := range moves {
+		if m.State == MoveRequested {
+			// Locked if its moveTo was committed
+			acc, accErr := c.clientConn[m.From].GetAccount(m.Contract)
+			if accErr != nil || acc == nil || strconv.Itoa(int(acc.ShardID)) != m.To {
+				c.moveTransition(m, MoveFailed, 0)
+				err = cause
+				continue
+			}
+			c.moveTransition(m, MoveToCommitted, 0)
+		}
+		moveErr := c.finishMove(m, false)
+		if moveErr != nil {
+			log.Warnf("[Client %v] Error finishing move %v of %v: %v", c.id, m.ID, m.Contract, moveErr)
+			err = moveErr
+			continue
+		}
+		moved++
+	}
+	return moved, err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/big"
+	"math/rand"
+
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/txs/payload"
+)
+
+// Tokens a spender is allowed to take on each approve
+const approvedTokens = 10
+
+// approval of a spender to take tokens from an Account
+type approval struct {
+	token     crypto.Address
+	remaining int64
+}
+
+// AddClient registers the address of client, a possible spender
+func (sc *ScalableCoin) AddClient(client int, address crypto.Address) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	if _, ok := sc.clientID[address]; !ok {
+		sc.clientAddresses = append(sc.clientAddresses, address)
+	}
+	sc.clientAddress[client] = address
+	sc.clientID[address] = client
+}
+
+func (sc *ScalableCoin) createAccountCall(token crypto.Address, method string, args ...interface{}) *payload.CallTx {
+	tx := payload.CallTx{
+		Input: &payload.TxInput{
+			Amount: 1,
+		},
+		Address:  &token,
+		Fee:      1,
+		GasLimit: 4100000000,
+	}
+
+	txInput, err := sc.accountABI.Methods[method].Inputs.Pack(args...)
+	fatalError(err)
+	tx.Data = append(sc.accountABI.Methods[method].Id(), txInput...)
+	return &tx
+}
+
+// Random client other than caller, clients register before the experiment
+// begins. Should hold the lock.
+func (sc *ScalableCoin) randomSpender(caller crypto.Address, rng *rand.Rand) (crypto.Address, bool) {
+	var others []crypto.Address
+	for _, address := range sc.clientAddresses {
+		if address != caller {
+			others = append(others, address)
+		}
+	}
+	if len(others) == 0 {
+		return crypto.ZeroAddress, false
+	}
+	return others[rng.Intn(len(others))], true
+}
+
+// getApprove lets a random client spend from token, should hold the lock
+func (sc *ScalableCoin) getApprove(token, caller crypto.Address, rng *rand.Rand) *Operation {
+	spender, ok := sc.randomSpender(caller, rng)
+	if !ok {
+		return nil
+	}
+	return &Operation{
+		Name:    "approve",
+		Tx:      sc.createAccountCall(token, "approve", spender, big.NewInt(approvedTokens)),
+		spender: spender,
+	}
+}
+
+// getTransferFrom takes a token approved to caller into token, which has to
+// move to the partition of the approving Account. Should hold the lock.
+func (sc *ScalableCoin) getTransferFrom(token, caller crypto.Address, rng *rand.Rand) *Operation {
+	approvals := sc.approvals[caller]
+	if len(approvals) == 0 {
+		return nil
+	}
+	owner := approvals[rng.Intn(len(approvals))].token
+	return sc.transferFromOp(owner, token)
+}
+
+func (sc *ScalableCoin) transferFromOp(owner, token crypto.Address) *Operation {
+	op := &Operation{
+		Name:    "transferFrom",
+		Tx:      sc.createAccountCall(owner, "transferFrom", token, big.NewInt(1)),
+		toToken: token,
+	}
+	ownerPartition, _ := sc.partitioning.Get(owner)
+	if partition, _ := sc.partitioning.Get(token); partition != ownerPartition {
+		op.moveToPartition = ownerPartition
+	}
+	return op
+}
+
+// getAllowance reads what a random client can spend from token, should hold the lock
+func (sc *ScalableCoin) getAllowance(token, caller crypto.Address, rng *rand.Rand) *Operation {
+	spender, ok := sc.randomSpender(caller, rng)
+	if !ok {
+		return nil
+	}
+	return &Operation{
+		Name:    "allowance",
+		Tx:      sc.createAccountCall(token, "allowance", spender),
+		spender: spender,
+	}
+}
+
+func (sc *ScalableCoin) getBalance(token crypto.Address) *Operation {
+	return &Operation{
+		Name: "balance",
+		Tx:   sc.createAccountCall(token, "balance"),
+	}
+}
+
+// Approved records that spender can take from token
+func (sc *ScalableCoin) Approved(token, spender crypto.Address) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	for _, a := range sc.approvals[spender] {
+		if a.token == token {
+			a.remaining = approvedTokens
+			return
+		}
+	}
+	sc.approvals[spender] = append(sc.approvals[spender], &approval{token: token, remaining: approvedTokens})
+}
+
+// TransferredFrom spends one approved token of owner
+func (sc *ScalableCoin) TransferredFrom(owner, spender crypto.Address) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	approvals := sc.approvals[spender]
+	for i, a := range approvals {
+		if a.token == owner {
+			a.remaining--
+			if a.remaining <= 0 {
+				sc.approvals[spender] = append(approvals[:i], approvals[i+1:]...)
+			}
+			return
+		}
+	}
+}
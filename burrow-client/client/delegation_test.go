@@ -0,0 +1,175 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/hyperledger/burrow/crypto"
+)
+
+const testAccountABI = `[
+{"type":"function","name":"moveTo","inputs":[{"name":"shardId","type":"uint256"}],"outputs":[]},
+{"type":"function","name":"balance","constant":true,"inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+{"type":"function","name":"allowance","constant":true,"inputs":[{"name":"_spender","type":"address"}],"outputs":[{"name":"","type":"uint256"}]},
+{"type":"function","name":"transfer","inputs":[{"name":"_to","type":"address"},{"name":"_tokens","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+{"type":"function","name":"approve","inputs":[{"name":"_spender","type":"address"},{"name":"_tokens","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+{"type":"function","name":"transferFrom","inputs":[{"name":"_to","type":"address"},{"name":"_tokens","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+// newTestScalableCoin has the Account ABI and nPartitions partitions, without
+// deployed contracts
+func newTestScalableCoin(t *testing.T, nPartitions int64) *ScalableCoin {
+	accountABI, err := abi.JSON(strings.NewReader(testAccountABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitioner := partitioning.NewHashPartitioning(nPartitions, partitioning.NewPlacement("modulo", nPartitions, 0, 0))
+	sc := &ScalableCoin{
+		accountABI:              accountABI,
+		partitioning:            partitioner,
+		governor:                partitioning.GetGovernor(partitioner),
+		targetPolicy:            NewTargetPolicy("uniform", 0, partitioner),
+		nPartitions:             nPartitions,
+		balancePrediction:       make([]int64, nPartitions),
+		contractsInShard:        make(map[int64]*rankedTokens),
+		tokenRank:               make(map[crypto.Address]int),
+		tokenOwner:              make(map[crypto.Address]int),
+		destinationDistribution: NewDistribution(config.Distribution{}),
+		tokenName:               make(map[crypto.Address]string),
+		tokenByName:             make(map[string]crypto.Address),
+		clientAddress:           make(map[int]crypto.Address),
+		clientID:                make(map[crypto.Address]int),
+		approvals:               make(map[crypto.Address][]*approval),
+		quarantined:             make(map[crypto.Address]bool),
+		crossShardCount:         make(map[crypto.Address]int),
+		factoryMethod:           "newAccount",
+		moveMethod:              "moveTo",
+	}
+	for i := int64(1); i <= nPartitions; i++ {
+		sc.contractsInShard[i] = newRankedTokens(sc.tokenRank)
+	}
+	return sc
+}
+
+func testAddress(b byte) crypto.Address {
+	var address crypto.Address
+	address[len(address)-1] = b
+	return address
+}
+
+func TestRandomSpender(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	rng := rand.New(rand.NewSource(1))
+	if _, ok := sc.randomSpender(testAddress(1), rng); ok {
+		t.Errorf("Spender without other clients")
+	}
+	// Client IDs are not contiguous
+	sc.AddClient(3, testAddress(1))
+	sc.AddClient(7, testAddress(2))
+	sc.AddClient(7, testAddress(2))
+	if len(sc.clientAddresses) != 2 {
+		t.Errorf("Clients registered twice: %v", sc.clientAddresses)
+	}
+	for i := 0; i < 20; i++ {
+		spender, ok := sc.randomSpender(testAddress(1), rng)
+		if !ok || spender != testAddress(2) {
+			t.Fatalf("Wrong spender %v %v", spender, ok)
+		}
+	}
+}
+
+func TestApprovals(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	rng := rand.New(rand.NewSource(1))
+	owner, token := testAddress(10), testAddress(11)
+	owner1, spender1 := testAddress(1), testAddress(2)
+	sc.AddClient(0, owner1)
+	sc.AddClient(1, spender1)
+	sc.AddToken(owner, 0, "0-0", 1)
+	sc.partitioning.Move(owner, 1)
+	sc.AddToken(token, 1, "1-0", 2)
+	sc.partitioning.Move(token, 2)
+
+	approve := sc.getApprove(owner, owner1, rng)
+	if approve == nil || approve.Name != "approve" || approve.spender != spender1 || *approve.Tx.Address != owner {
+		t.Fatalf("Wrong approve %+v", approve)
+	}
+	// Nothing approved to the spender yet
+	if op := sc.getTransferFrom(token, spender1, rng); op != nil {
+		t.Errorf("transferFrom without approval: %+v", op)
+	}
+
+	sc.Approved(owner, spender1)
+	transferFrom := sc.getTransferFrom(token, spender1, rng)
+	if transferFrom == nil || *transferFrom.Tx.Address != owner || transferFrom.toToken != token {
+		t.Fatalf("Wrong transferFrom %+v", transferFrom)
+	}
+	// The spender token joins the owner Account
+	if transferFrom.moveToPartition != 1 {
+		t.Errorf("transferFrom moves to %v, expected 1", transferFrom.moveToPartition)
+	}
+
+	// Approving again renews the tokens instead of adding an approval
+	sc.TransferredFrom(owner, spender1)
+	sc.Approved(owner, spender1)
+	if len(sc.approvals[spender1]) != 1 || sc.approvals[spender1][0].remaining != approvedTokens {
+		t.Errorf("Wrong approvals after renewing: %+v", sc.approvals[spender1])
+	}
+	for i := 0; i < approvedTokens; i++ {
+		sc.TransferredFrom(owner, spender1)
+	}
+	if len(sc.approvals[spender1]) != 0 {
+		t.Errorf("Approval not spent: %+v", sc.approvals[spender1])
+	}
+}
+
+func TestReplayDelegationOps(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	owner, token := testAddress(10), testAddress(11)
+	spender := testAddress(2)
+	sc.AddClient(1, spender)
+	sc.AddToken(owner, 0, "0-0", 1)
+	sc.partitioning.Move(owner, 1)
+	sc.AddToken(token, 1, "1-0", 2)
+	sc.partitioning.Move(token, 2)
+
+	for _, tc := range []struct {
+		traceOp TraceOp
+		ok      bool
+	}{
+		{TraceOp{Name: "approve", From: "0-0", To: "1"}, true},
+		{TraceOp{Name: "allowance", From: "0-0", To: "1"}, true},
+		{TraceOp{Name: "balance", From: "0-0", To: "-"}, true},
+		{TraceOp{Name: "transferFrom", From: "0-0", To: "1-0"}, true},
+		// Client 5 and token 2-0 are not registered
+		{TraceOp{Name: "approve", From: "0-0", To: "5"}, false},
+		{TraceOp{Name: "transferFrom", From: "0-0", To: "2-0"}, false},
+		{TraceOp{Name: "balance", From: "2-0", To: "-"}, false},
+	} {
+		op, ok := sc.ReplayOp(tc.traceOp)
+		if ok != tc.ok {
+			t.Errorf("%+v: replayed %v, expected %v", tc.traceOp, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if op.Name != tc.traceOp.Name || *op.Tx.Address != owner {
+			t.Errorf("%+v: wrong op %+v", tc.traceOp, op)
+		}
+		switch op.Name {
+		case "approve", "allowance":
+			if op.spender != spender {
+				t.Errorf("%v: spender %v, expected %v", op.Name, op.spender, spender)
+			}
+		case "transferFrom":
+			if op.toToken != token || op.moveToPartition != 1 {
+				t.Errorf("transferFrom: wrong token %v or partition %v", op.toToken, op.moveToPartition)
+			}
+		}
+	}
+}
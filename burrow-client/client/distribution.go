@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/hyperledger/burrow/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// Distribution picks an index in [0, n) using rng, lower indexes are the
+// popular ones. n may grow between calls as contracts are created.
+type Distribution interface {
+	Pick(rng *rand.Rand, n int) int
+}
+
+func NewDistribution(dc config.Distribution) Distribution {
+	switch dc.Type {
+	case "uniform", "":
+		return &UniformDistribution{}
+	case "zipf":
+		return &ZipfDistribution{exponent: dc.Exponent}
+	case "hotSet":
+		return &HotSetDistribution{fraction: dc.HotFraction, probability: dc.HotProbability}
+	}
+	log.Fatalf("Unknown distribution %v", dc.Type)
+	return nil
+}
+
+type UniformDistribution struct{}
+
+func (ud *UniformDistribution) Pick(rng *rand.Rand, n int) int {
+	return rng.Intn(n)
+}
+
+// ZipfDistribution picks i with probability proportional to 1/(i+1)^exponent,
+// any exponent >= 0 is allowed (rand.Zipf requires > 1)
+type ZipfDistribution struct {
+	exponent float64
+	// Cumulative weights, extended as n grows
+	cdf []float64
+}
+
+func (zd *ZipfDistribution) Pick(rng *rand.Rand, n int) int {
+	for len(zd.cdf) < n {
+		total := 0.0
+		if len(zd.cdf) > 0 {
+			total = zd.cdf[len(zd.cdf)-1]
+		}
+		zd.cdf = append(zd.cdf, total+1/math.Pow(float64(len(zd.cdf)+1), zd.exponent))
+	}
+	return sort.SearchFloat64s(zd.cdf[:n], rng.Float64()*zd.cdf[n-1])
+}
+
+// HotSetDistribution picks from the first fraction of the indexes with
+// probability, otherwise from the rest
+type HotSetDistribution struct {
+	fraction    float64
+	probability float64
+}
+
+func (hd *HotSetDistribution) Pick(rng *rand.Rand, n int) int {
+	hot := int(math.Ceil(hd.fraction * float64(n)))
+	if hot >= n {
+		return rng.Intn(n)
+	}
+	if hot < 1 {
+		hot = 1
+	}
+	if rng.Float64() < hd.probability {
+		return rng.Intn(hot)
+	}
+	return hot + rng.Intn(n-hot)
+}
+
+// rankedTokens are the tokens of a partition sorted by their global rank, so
+// the popular tokens stay popular wherever they move
+type rankedTokens struct {
+	tokens []crypto.Address
+	rank   map[crypto.Address]int
+}
+
+func newRankedTokens(rank map[crypto.Address]int) *rankedTokens {
+	return &rankedTokens{rank: rank}
+}
+
+// Position of token, or where to insert it
+func (rt *rankedTokens) search(token crypto.Address) int {
+	rank := rt.rank[token]
+	return sort.Search(len(rt.tokens), func(i int) bool { return rt.rank[rt.tokens[i]] >= rank })
+}
+
+func (rt *rankedTokens) add(token crypto.Address) {
+	i := rt.search(token)
+	if i < len(rt.tokens) && rt.tokens[i] == token {
+		return
+	}
+	rt.tokens = append(rt.tokens, crypto.Address{})
+	copy(rt.tokens[i+1:], rt.tokens[i:])
+	rt.tokens[i] = token
+}
+
+func (rt *rankedTokens) remove(token crypto.Address) {
+	i := rt.search(token)
+	if i < len(rt.tokens) && rt.tokens[i] == token {
+		rt.tokens = append(rt.tokens[:i], rt.tokens[i+1:]...)
+	}
+}
+
+func (rt *rankedTokens) len() int {
+	return len(rt.tokens)
+}
+
+func (rt *rankedTokens) pick(rng *rand.Rand, distribution Distribution) crypto.Address {
+	return rt.tokens[distribution.Pick(rng, len(rt.tokens))]
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/hyperledger/burrow/rpc/rpcevents"
+)
+
+// Signed headers kept per shard by default
+const defaultHeaderCache = 64
+
+// headerRing keeps the last signed headers of a shard
+type headerRing struct {
+	headers []*rpcevents.SignedHeadersResult
+	// Height of the last header added
+	last int64
+}
+
+func newHeaderRing(size int) *headerRing {
+	return &headerRing{headers: make([]*rpcevents.SignedHeadersResult, size)}
+}
+
+func (r *headerRing) add(signedBlock *rpcevents.SignedHeadersResult) {
+	height := signedBlock.SignedHeader.Height
+	r.headers[height%int64(len(r.headers))] = signedBlock
+	r.passed(height)
+}
+
+// passed records that the header at height went by, kept or not
+func (r *headerRing) passed(height int64) {
+	if height > r.last {
+		r.last = height
+	}
+}
+
+// get returns the header at height if still kept
+func (r *headerRing) get(height int64) (*rpcevents.SignedHeadersResult, bool) {
+	signedBlock := r.headers[height%int64(len(r.headers))]
+	if signedBlock == nil || signedBlock.SignedHeader.Height != height {
+		return nil, false
+	}
+	return signedBlock, true
+}
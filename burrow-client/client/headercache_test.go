@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/burrow/rpc/rpcevents"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+	"github.com/tendermint/tendermint/types"
+)
+
+func testSignedBlock(height int64) *rpcevents.SignedHeadersResult {
+	return &rpcevents.SignedHeadersResult{
+		SignedHeader: &rpcquery.SignedHeader{Header: &types.Header{ChainID: "1", Height: height}},
+	}
+}
+
+func TestHeaderRing(t *testing.T) {
+	ring := newHeaderRing(4)
+	if _, ok := ring.get(0); ok {
+		t.Errorf("Header got from an empty ring")
+	}
+	for height := int64(1); height <= 3; height++ {
+		ring.add(testSignedBlock(height))
+	}
+	for height := int64(1); height <= 3; height++ {
+		signedBlock, ok := ring.get(height)
+		if !ok || signedBlock.SignedHeader.Height != height {
+			t.Errorf("Header %v not kept", height)
+		}
+	}
+	if _, ok := ring.get(4); ok || ring.last != 3 {
+		t.Errorf("Header 4 got before being added, last %v", ring.last)
+	}
+
+	// Wraps around, evicting the oldest headers
+	for height := int64(4); height <= 6; height++ {
+		ring.add(testSignedBlock(height))
+	}
+	for height := int64(1); height <= 2; height++ {
+		if _, ok := ring.get(height); ok {
+			t.Errorf("Header %v not evicted", height)
+		}
+	}
+	for height := int64(3); height <= 6; height++ {
+		signedBlock, ok := ring.get(height)
+		if !ok || signedBlock.SignedHeader.Height != height {
+			t.Errorf("Header %v not kept after wrapping around", height)
+		}
+	}
+	// A header added late does not move last back
+	ring.add(testSignedBlock(5))
+	if ring.last != 6 {
+		t.Errorf("Last header %v, expected 6", ring.last)
+	}
+	// Heights passed without a header are not kept
+	ring.passed(7)
+	if _, ok := ring.get(7); ok || ring.last != 7 {
+		t.Errorf("Header 7 got without being added, last %v", ring.last)
+	}
+	if _, ok := ring.get(3); !ok {
+		t.Errorf("Header 3 evicted by a height passed")
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/lightclient"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/hyperledger/burrow/acm"
@@ -30,14 +32,77 @@ type ScalableCoin struct {
 	logs         *utils.Log
 	logger       *logging.Logger
 
-	partitioning        *HashPartitioning
+	partitioning        partitioning.Partitioning
+	placement           partitioning.Placement
+	governor            *partitioning.MoveGovernor
+	costModel           *partitioning.MeasuredCostModel
+	targetPolicy        TargetPolicy
 	reachedMaxContracts bool
 
+	nPartitions          int64
+	crossShardPercentage float32
+	// Share of the contracts moved to a shard added by a scale out
+	scaleOutShare float64
+
 	balancePrediction []int64
 	sync.RWMutex
 	nextPartition int64
 
-	contractsInShard map[int64]map[crypto.Address]bool
+	contractsInShard map[int64]*rankedTokens
+	// Creation order of the tokens, lower ranks are picked more often
+	tokenRank map[crypto.Address]int
+	// Tokens by rank and the client owning them
+	tokens     []crypto.Address
+	tokenOwner map[crypto.Address]int
+	// Access distributions of the source (per client) and destination tokens
+	sourceDistribution      config.Distribution
+	destinationDistribution Distribution
+	// Client i draws operations with seed+i
+	seed int64
+
+	// Names of the tokens in traces
+	tokenName   map[crypto.Address]string
+	tokenByName map[string]crypto.Address
+	recordTrace bool
+	// Operations of each client when replaying a trace, nil otherwise
+	trace map[int][]TraceOp
+
+	// Same-shard transfers in flight per client and shard, txs are given up after txTimeout
+	outstandingTxs int
+	txTimeout      time.Duration
+	txTracker      *TxTracker
+
+	// Fraction of each operation type, the rest are transfers
+	opMix         config.OpMix
+	clientAddress map[int]crypto.Address
+	clientID      map[crypto.Address]int
+	// Registered client addresses, to pick spenders from
+	clientAddresses []crypto.Address
+	// Approvals by spender
+	approvals map[crypto.Address][]*approval
+
+	// Calls of the workload spec instead of the transfers, nil if not given
+	workload *Workload
+	// Methods creating and moving the objects
+	factoryMethod string
+	moveMethod    string
+
+	// Journal of the moves, nil if not kept, and the moves a previous run left
+	// half-finished by client
+	moveJournal     *MoveJournal
+	unfinishedMoves map[int][]*Move
+	// Move several contracts between two shards in one round trip
+	batchMoves bool
+	// Replay the moves of single contracts with stale and wrong proofs
+	attackMoves bool
+	// Moves are aborted after moveDeadlineBlocks blocks or moveDeadlineTime
+	moveDeadlineBlocks int64
+	moveDeadlineTime   time.Duration
+	moveOnAbort        string
+	// Contracts left locked by aborted moves, not operated on
+	quarantined map[crypto.Address]bool
+	// Verifies headers and proofs of the shards with a genesis, nil if none
+	lightClient *lightclient.LightClient
 	// allowedCrossShard map[int64]map[crypto.Address]bool
 
 	crossShardCount map[crypto.Address]int
@@ -47,7 +112,7 @@ type ScalableCoin struct {
 	staticToken map[int64][]crypto.Address
 }
 
-func (sc *ScalableCoin) GetCrossShardRandom(fromToken crypto.Address, fromPartition, toPartition int64) (crypto.Address, error) {
+func (sc *ScalableCoin) GetCrossShardRandom(fromToken crypto.Address, fromPartition, toPartition int64, rng *rand.Rand) (crypto.Address, error) {
 	// if !sc.allowedCrossShard[fromPartition][fromToken] {
 	// 	return crypto.ZeroAddress, fmt.Errorf("Cannot do cross-shard")
 	// 	log.Fatalf("Contract %v not allowed to cross shard", fromToken)
@@ -55,10 +120,10 @@ func (sc *ScalableCoin) GetCrossShardRandom(fromToken crypto.Address, fromPartit
 
 	// delete(sc.contractsInShard[fromPartition], fromToken)
 
-	for token := range sc.contractsInShard[toPartition] {
+	if sc.contractsInShard[toPartition].len() != 0 {
 		// 	sc.crossShardCount[token]++
 		// 	delete(sc.allowedCrossShard[toPartition], token)
-		return token, nil
+		return sc.contractsInShard[toPartition].pick(rng, sc.destinationDistribution), nil
 	}
 	log.Fatalf("Should not happen")
 	return crypto.ZeroAddress, nil
@@ -68,7 +133,7 @@ func (sc *ScalableCoin) GetCrossShardRandom(fromToken crypto.Address, fromPartit
 
 }
 
-func (sc *ScalableCoin) GetSameShardRandom(partition int64) crypto.Address {
+func (sc *ScalableCoin) GetSameShardRandom(partition int64, rng *rand.Rand) crypto.Address {
 	// sc.contractsInShardMutex.Lock()
 	// defer sc.contractsInShardMutex.Unlock()
 	// sc.Lock()
@@ -79,9 +144,9 @@ func (sc *ScalableCoin) GetSameShardRandom(partition int64) crypto.Address {
 	// 	return token
 	// }
 
-	for token := range sc.contractsInShard[partition] {
+	if sc.contractsInShard[partition].len() != 0 {
 		// 	// delete(sc.allowedCrossShard[partition], token)
-		return token
+		return sc.contractsInShard[partition].pick(rng, sc.destinationDistribution)
 	}
 
 	log.Fatalf("Should not happen")
@@ -92,8 +157,8 @@ func (sc *ScalableCoin) FinishCrossShard(fromToken, toToken crypto.Address, from
 	sc.Lock()
 	defer sc.Unlock()
 
-	sc.contractsInShard[fromPartition][fromToken] = true
-	delete(sc.contractsInShard[toPartition], fromToken)
+	sc.contractsInShard[fromPartition].add(fromToken)
+	sc.contractsInShard[toPartition].remove(fromToken)
 
 	// sc.crossShardCount[toToken]--
 	// if sc.crossShardCount[toToken] == 0 {
@@ -111,11 +176,19 @@ func (sc *ScalableCoin) FinishSameShard(toToken crypto.Address, toPartition int6
 	// }
 }
 
-func (sc *ScalableCoin) AddToken(token crypto.Address, partition int64) {
+func (sc *ScalableCoin) AddToken(token crypto.Address, owner int, name string, partition int64) {
 	sc.Lock()
 	defer sc.Unlock()
 
-	sc.contractsInShard[partition][token] = true
+	sc.tokenName[token] = name
+	sc.tokenByName[name] = token
+	sc.tokenOwner[token] = owner
+
+	if _, ok := sc.tokenRank[token]; !ok {
+		sc.tokenRank[token] = len(sc.tokenRank)
+		sc.tokens = append(sc.tokens, token)
+	}
+	sc.contractsInShard[partition].add(token)
 	// sc.allowedCrossShard[partition][token] = true
 }
 
@@ -129,22 +202,59 @@ func (sc *ScalableCoin) AddStaticToken(token crypto.Address, partition int64) {
 	// sc.allowedCrossShard[partition][token] = true
 }
 
+// AddPartition starts using one more shard
+func (sc *ScalableCoin) AddPartition() int64 {
+	sc.Lock()
+	defer sc.Unlock()
+
+	partition := sc.partitioning.AddPartition()
+	sc.nPartitions = partition
+	sc.balancePrediction = append(sc.balancePrediction, 0)
+	sc.contractsInShard[partition] = newRankedTokens(sc.tokenRank)
+	return partition
+}
+
+// Quarantine stops picking token, locked in partition by an aborted move
+func (sc *ScalableCoin) Quarantine(token crypto.Address, partition int64) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.quarantined[token] = true
+	sc.contractsInShard[partition].remove(token)
+}
+
+func (sc *ScalableCoin) NumberPartitions() int64 {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.nPartitions
+}
+
+// Migrated moves token between shards outside of a transfer (rebalancing)
+func (sc *ScalableCoin) Migrated(token crypto.Address, fromPartition, toPartition int64) {
+	sc.Lock()
+	defer sc.Unlock()
+
+	sc.contractsInShard[fromPartition].remove(token)
+	sc.contractsInShard[toPartition].add(token)
+}
+
 func NewScalableCoinAPI(config *config.Config, logs *utils.Log) *ScalableCoin {
 	contractABIJson, err := os.Open(config.Contracts.CKABI)
 	fatalError(err)
 	contractABI, err := abi.JSON(contractABIJson)
 	fatalError(err)
 
-	Json, err :=accountABI os.Open(config.Contracts.KittyABI)
+	accountABIJson, err := os.Open(config.Contracts.KittyABI)
 	fatalError(err)
 	accountABI, err := abi.JSON(accountABIJson)
 	fatalError(err)
 
-	crossShardPercentage := config.Benchmark.CrossShardPercentage
-	createContractPercentage := config.Benchmark.CreateContractPercentage
-	maximumAccounts := config.Benchmark.MaximumAccounts
-
-	partitioning := NewHashPartitioning(config.Partitioning.NumberPartitions, crossShardPercentage, createContractPercentage, maximumAccounts)
+	// Initial shard of a contract is reproducible from its address
+	if config.Partitioning.Placement == "" {
+		config.Partitioning.Placement = "keccak"
+	}
+	placement := partitioning.NewPlacement(config.Partitioning.Placement, config.Partitioning.NumberPartitions, config.Partitioning.VirtualNodes, config.Partitioning.RangeMax)
+	partitioner := partitioning.GetPartitioning(config, logs)
 	var balancePrediction []int64
 	for i := int64(0); i < config.Partitioning.NumberPartitions; i++ {
 		balancePrediction = append(balancePrediction, 0)
@@ -152,33 +262,114 @@ func NewScalableCoinAPI(config *config.Config, logs *utils.Log) *ScalableCoin {
 	sc := &ScalableCoin{
 		abi:               contractABI,
 		accountABI:        accountABI,
-		partitioning:      partitioning,
+		partitioning:      partitioner,
+		placement:         placement,
+		governor:          partitioning.GetGovernor(partitioner),
+		costModel:         partitioning.GetCostModel(partitioner),
+		targetPolicy:      NewTargetPolicy(config.Benchmark.TargetPolicy, config.Benchmark.Locality, partitioner),
 		logs:              logs,
 		logger:            logging.NewNoopLogger(),
 		balancePrediction: balancePrediction,
-		contractsInShard:  make(map[int64]map[crypto.Address]bool),
+
+		nPartitions:          config.Partitioning.NumberPartitions,
+		crossShardPercentage: config.Benchmark.CrossShardPercentage,
+		scaleOutShare:        config.Partitioning.ScaleOut.Share,
+		batchMoves:           config.Benchmark.BatchMoves,
+		attackMoves:          config.Benchmark.AttackMoves,
+		moveDeadlineBlocks:   config.Benchmark.MoveDeadline.Blocks,
+		moveDeadlineTime:     config.Benchmark.MoveDeadline.Time * time.Second,
+		moveOnAbort:          config.Benchmark.MoveDeadline.OnAbort,
+		quarantined:          make(map[crypto.Address]bool),
+
+		contractsInShard: make(map[int64]*rankedTokens),
+		tokenRank:        make(map[crypto.Address]int),
+		tokenOwner:       make(map[crypto.Address]int),
+
+		sourceDistribution:      config.Benchmark.SourceDistribution,
+		destinationDistribution: NewDistribution(config.Benchmark.DestinationDistribution),
+		seed:                    config.Benchmark.Seed,
+
+		tokenName:   make(map[crypto.Address]string),
+		tokenByName: make(map[string]crypto.Address),
+		recordTrace: config.Benchmark.RecordTrace,
+
+		opMix:         config.Benchmark.OpMix,
+		clientAddress: make(map[int]crypto.Address),
+		clientID:      make(map[crypto.Address]int),
+		approvals:     make(map[crypto.Address][]*approval),
+		factoryMethod: "newAccount",
+		moveMethod:    "moveTo",
 		// allowedCrossShard: make(map[int64]map[crypto.Address]bool),
 		crossShardCount: make(map[crypto.Address]int),
 
 		staticToken: make(map[int64][]crypto.Address),
 	}
 	for i := int64(1); i <= config.Partitioning.NumberPartitions; i++ {
-		sc.contractsInShard[i] = make(map[crypto.Address]bool)
+		sc.contractsInShard[i] = newRankedTokens(sc.tokenRank)
 		// sc.allowedCrossShard[i] = make(map[crypto.Address]bool)
 	}
+	sc.outstandingTxs = config.Benchmark.OutstandingTxs
+	// Open-loop transfers only wait for the window
+	if sc.outstandingTxs <= 1 {
+		sc.outstandingTxs = openLoopMaxInFlight(config)
+	}
+	if sc.outstandingTxs > 1 {
+		sc.txTimeout = time.Duration(config.Benchmark.Timeout) * time.Second
+		if sc.txTimeout == 0 {
+			sc.txTimeout = time.Minute
+		}
+		sc.txTracker = NewTxTracker()
+	}
+	if config.Benchmark.Workload != "" {
+		if config.Benchmark.ReplayTrace != "" {
+			log.Fatalf("Workload spec operations cannot be replayed")
+		}
+		sc.workload, err = LoadWorkload(config.Benchmark.Workload)
+		fatalError(err)
+		if _, ok := contractABI.Methods[sc.workload.factory]; !ok {
+			log.Fatalf("Factory method %v not in %v", sc.workload.factory, config.Contracts.CKABI)
+		}
+		sc.accountABI = sc.workload.abi
+		sc.factoryMethod = sc.workload.factory
+		sc.moveMethod = sc.workload.move
+		log.Infof("Workload of %v methods from %v", len(sc.workload.methods), config.Benchmark.Workload)
+	}
+	if config.Benchmark.MoveJournal != "" {
+		sc.unfinishedMoves, err = LoadUnfinishedMoves(config.Benchmark.MoveJournal)
+		fatalError(err)
+		sc.moveJournal, err = OpenMoveJournal(config.Benchmark.MoveJournal)
+		fatalError(err)
+		for client, moves := range sc.unfinishedMoves {
+			log.Infof("Client %v has %v unfinished moves", client, len(moves))
+		}
+	}
+	if sc.moveOnAbort != "" && sc.moveOnAbort != "rehome" && sc.moveOnAbort != "quarantine" {
+		log.Fatalf("Unknown onAbort %v", sc.moveOnAbort)
+	}
+	sc.lightClient, err = lightclient.New(config, logs)
+	fatalError(err)
+	if config.Benchmark.ReplayTrace != "" {
+		sc.trace, err = LoadTrace(config.Benchmark.ReplayTrace)
+		fatalError(err)
+		log.Infof("Replaying operations of %v clients from %v", len(sc.trace), config.Benchmark.ReplayTrace)
+	}
 
 	go func() {
 		for {
 			logs.Log("balance", "%d ", time.Now().UnixNano())
 			sc.Lock()
 			elementsInEachPart := sc.partitioning.GetElementsInEachPart()
-			for i := int64(1); i <= config.Partitioning.NumberPartitions; i++ {
+			for i := int64(1); i <= sc.nPartitions; i++ {
 				sc.balancePrediction[i-1] = elementsInEachPart[i]
 				logs.Log("balance", "%d ", elementsInEachPart[i])
 			}
 			log.Infof("Balance: %v", elementsInEachPart)
 			sc.Unlock()
-			logs.Log("balance", "\n")
+			logs.Log("balance", "%v\n", sc.targetPolicy.Name())
+			if sc.governor != nil {
+				counters := sc.governor.Counters()
+				logs.Log("governor", "%d %d %d %d\n", time.Now().UnixNano(), counters.Residence, counters.Cap, counters.Forced)
+			}
 			time.Sleep(time.Minute)
 		}
 	}()
@@ -236,8 +427,10 @@ func (sc *ScalableCoin) CreateContract(client *def.Client, chainID, codePath str
 type Operation struct {
 	Name            string
 	Tx              *payload.CallTx
-	moveToPartition int64 // To partition
+	moveToPartition int64 // To partition, of Tx.Address or of toToken for transferFrom
 	toToken         crypto.Address
+	spender         crypto.Address // approve and allowance
+	spec            bool           // Call of the workload spec, toToken is its first object argument
 }
 
 func (sc *ScalableCoin) createNewAccount() *payload.CallTx {
@@ -250,9 +443,9 @@ func (sc *ScalableCoin) createNewAccount() *payload.CallTx {
 		GasLimit: 4100000000,
 	}
 
-	txInput, err := sc.abi.Methods["newAccount"].Inputs.Pack()
+	txInput, err := sc.abi.Methods[sc.factoryMethod].Inputs.Pack()
 	fatalError(err)
-	tx.Data = append(sc.abi.Methods["newAccount"].Id(), txInput...)
+	tx.Data = append(sc.abi.Methods[sc.factoryMethod].Id(), txInput...)
 	return &tx
 }
 
@@ -283,9 +476,9 @@ func (sc *ScalableCoin) createMoveTo(token crypto.Address, from int) *payload.Ca
 		GasLimit: 4100000000,
 	}
 
-	txInput, err := sc.accountABI.Methods["moveTo"].Inputs.Pack(big.NewInt(int64(from)))
+	txInput, err := sc.accountABI.Methods[sc.moveMethod].Inputs.Pack(big.NewInt(int64(from)))
 	fatalError(err)
-	moveToTx.Data = append(sc.accountABI.Methods["moveTo"].Id(), txInput...)
+	moveToTx.Data = append(sc.accountABI.Methods[sc.moveMethod].Id(), txInput...)
 	return moveToTx
 }
 
@@ -310,25 +503,66 @@ func (sc *ScalableCoin) extractContractAddress(event binary.HexBytes) *crypto.Ad
 }
 
 func (sc *ScalableCoin) GetNext() int64 {
-	sc.nextPartition = (sc.nextPartition + 1) % sc.partitioning.nPartitions
+	sc.nextPartition = (sc.nextPartition + 1) % sc.nPartitions
 	return sc.nextPartition + 1
 }
 
-func (sc *ScalableCoin) GetOp(token crypto.Address) *Operation {
+// GetOp draws the next operation of token owned by caller, rng is the one of caller
+func (sc *ScalableCoin) GetOp(token, caller crypto.Address, rng *rand.Rand) *Operation {
 	sc.Lock()
-	sc.partitioning.RLock()
-	defer sc.partitioning.RUnlock()
 	defer sc.Unlock()
 
+	if sc.workload != nil {
+		return sc.getWorkloadOp(token, caller, rng)
+	}
+
+	var mixOp *Operation
+	opToss := rng.Float32()
+	mix := sc.opMix
+	switch {
+	case opToss < mix.Approve:
+		mixOp = sc.getApprove(token, caller, rng)
+	case opToss < mix.Approve+mix.TransferFrom:
+		mixOp = sc.getTransferFrom(token, caller, rng)
+	case opToss < mix.Approve+mix.TransferFrom+mix.Allowance:
+		mixOp = sc.getAllowance(token, caller, rng)
+	case opToss < mix.Approve+mix.TransferFrom+mix.Allowance+mix.Balance:
+		mixOp = sc.getBalance(token)
+	}
+	// Without clients or approvals to use, transfer
+	if mixOp != nil {
+		return mixOp
+	}
+
 	op := Operation{}
 
 	op.Name = "transfer"
-	fromPartition := sc.partitioning.partitionMap[token]
+	fromPartition, _ := sc.partitioning.Get(token)
+	toToken, randPartition := sc.pickDestination(token, fromPartition, rng)
+	op.toToken = toToken
+	op.Tx = sc.createTransfer(token, toToken)
+	if randPartition == fromPartition {
+		// Sameshard
+		debug("Creating same-shard transfer on partition %v from: %v, to: %v", fromPartition, token, op.toToken)
+		// log.Infof("Creating same-shard transfer on partition %v from: %v, to: %v", fromPartition, token, op.toToken)
+	} else {
+		op.moveToPartition = randPartition
+		debug("Creating cross-shard transfer from partition %v to partition: %v, from token %v, to token: %v", fromPartition, randPartition, token, op.toToken)
+		// log.Infof("Creating cross-shard transfer from partition %v to partition: %v, from token %v, to token: %v", fromPartition, randPartition, token, op.toToken)
+	}
+	sc.governor.Access(token, op.toToken)
+	sc.targetPolicy.Accessed(token, op.toToken)
+	return &op
+}
+
+// pickDestination picks the token a transfer from token goes to and its
+// partition, which token moves to if not fromPartition. Should hold the lock.
+func (sc *ScalableCoin) pickDestination(token crypto.Address, fromPartition int64, rng *rand.Rand) (crypto.Address, int64) {
 	var randPartition int64
 	var toCrossShardToken crypto.Address
 	var crossShardToss float32
-	crossShardToss = rand.Float32()
-	if crossShardToss < sc.partitioning.crossShardPercentage {
+	crossShardToss = rng.Float32()
+	if crossShardToss < sc.crossShardPercentage {
 		// Not allowed to make crossshard
 		// if !sc.allowedCrossShard[fromPartition][token] {
 		// 	log.Warnf("Contract not allowed to make cross-shard")
@@ -336,38 +570,20 @@ func (sc *ScalableCoin) GetOp(token crypto.Address) *Operation {
 		// 	sc.shouldCrossShard++
 		// 	goto decision
 		// } else {
-		// randPartition = sc.partitioning.crossShardRandChoice[fromPartition-1][rand.Intn(int(sc.partitioning.nPartitions-1))] + 1
-		mostUnbalancedPartition := 0
-		secondMostUnbalanced := 1
-
-		for idx, bal := range sc.balancePrediction {
-			if bal < sc.balancePrediction[mostUnbalancedPartition] {
-				secondMostUnbalanced = mostUnbalancedPartition
-				mostUnbalancedPartition = idx
-			} else if bal < sc.balancePrediction[secondMostUnbalanced] && idx != mostUnbalancedPartition {
-				secondMostUnbalanced = idx
-			}
-		}
-		if mostUnbalancedPartition == secondMostUnbalanced {
-			log.Fatalf("Should not happen %v %v", mostUnbalancedPartition, secondMostUnbalanced)
-		}
-		// randPartition = sc.partitioning.GetMostUnbalanced()
-		randPartition = int64(mostUnbalancedPartition + 1)
-		if fromPartition == randPartition {
-			randPartition = int64(secondMostUnbalanced + 1)
-		}
-		sc.balancePrediction[fromPartition-1]--
-		sc.balancePrediction[randPartition-1]++
+		randPartition = sc.targetPolicy.Target(rng, token, fromPartition, sc.balancePrediction)
 		// }
 
 		// randPartition = sc.partitioning.crossShardRandChoice[fromPartition-1][rand.Intn(int(sc.partitioning.nPartitions-1))] + 1
 
-		if len(sc.partitioning.partitionObjMap[randPartition]) == 0 {
-			randPartition = fromPartition
+		// Quarantined tokens are not picked
+		if sc.contractsInShard[randPartition].len() == 0 {
 			log.Warnf("No objects in partition %v for cross-shard", randPartition)
+			randPartition = fromPartition
+		} else if randPartition != fromPartition && !sc.governor.AllowMove(token, fromPartition, randPartition) {
+			// Avoid bouncing the token between shards, reserved once the
+			// destination is final
+			randPartition = fromPartition
 		}
-		// var err error
-		toCrossShardToken, _ = sc.GetCrossShardRandom(token, fromPartition, randPartition)
 		// if err != nil {
 		// 	randPartition = fromPartition
 		// 	sc.shouldCrossShard++
@@ -377,30 +593,42 @@ func (sc *ScalableCoin) GetOp(token crypto.Address) *Operation {
 	} else {
 		randPartition = fromPartition
 	}
+	// Every token of the shard quarantined, the transfer crosses shards
+	if randPartition == fromPartition && sc.contractsInShard[fromPartition].len() == 0 {
+		randPartition = sc.pickablePartition(rng)
+		log.Warnf("No objects in partition %v for same-shard, crossing to %v", fromPartition, randPartition)
+	}
 	// decision:
 	if randPartition == fromPartition {
-		// Sameshard
-		toToken := sc.GetSameShardRandom(fromPartition)
-		op.toToken = toToken
-		op.Tx = sc.createTransfer(token, toToken)
-		debug("Creating same-shard transfer on partition %v from: %v, to: %v", fromPartition, token, op.toToken)
-		// log.Infof("Creating same-shard transfer on partition %v from: %v, to: %v", fromPartition, token, op.toToken)
-	} else {
-		// toToken := sc.GetCrossShardRandom(token, fromPartition, randPartition)
-		op.toToken = toCrossShardToken
-		op.Tx = sc.createTransfer(token, toCrossShardToken)
-		op.moveToPartition = randPartition
-		debug("Creating cross-shard transfer from partition %v to partition: %v, from token %v, to token: %v", fromPartition, randPartition, token, op.toToken)
-		// log.Infof("Creating cross-shard transfer from partition %v to partition: %v, from token %v, to token: %v", fromPartition, randPartition, token, op.toToken)
+		return sc.GetSameShardRandom(fromPartition, rng), fromPartition
 	}
-	return &op
+	sc.balancePrediction[fromPartition-1]--
+	sc.balancePrediction[randPartition-1]++
+	toCrossShardToken, _ = sc.GetCrossShardRandom(token, fromPartition, randPartition, rng)
+	return toCrossShardToken, randPartition
+}
+
+// pickablePartition picks a partition with tokens not quarantined
+func (sc *ScalableCoin) pickablePartition(rng *rand.Rand) int64 {
+	var partitions []int64
+	for partition := int64(1); partition <= sc.nPartitions; partition++ {
+		if sc.contractsInShard[partition].len() != 0 {
+			partitions = append(partitions, partition)
+		}
+	}
+	if len(partitions) == 0 {
+		log.Fatalf("Every token is quarantined")
+	}
+	return partitions[rng.Intn(len(partitions))]
 }
 
 func (sc *ScalableCoin) GetRetryOp(token crypto.Address, op *Operation) {
-	sc.partitioning.RLock()
-	defer sc.partitioning.RUnlock()
-	partition := sc.partitioning.partitionMap[token]
-	toTokenPartition := sc.partitioning.partitionMap[op.toToken]
+	// Workload calls without object arguments do not move
+	if op.spec && op.toToken == (crypto.Address{}) {
+		return
+	}
+	partition, _ := sc.partitioning.Get(token)
+	toTokenPartition, _ := sc.partitioning.Get(op.toToken)
 	if partition == toTokenPartition {
 		op.moveToPartition = 0
 	} else {
@@ -2,9 +2,12 @@ package main
 
 import (
 	"io/ioutil"
+	"math/rand"
+	"strconv"
 	"testing"
 
 	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
 	"github.com/hyperledger/burrow/crypto"
 	yaml "gopkg.in/yaml.v2"
@@ -18,9 +21,83 @@ func TestGetOp(t *testing.T) {
 	logs, err := utils.NewLog(config.Logs.Dir)
 	scalableCoin := NewScalableCoinAPI(&config, logs)
 
-	scalableCoin.GetOp(crypto.ZeroAddress)
+	scalableCoin.GetOp(crypto.ZeroAddress, crypto.ZeroAddress, rand.New(rand.NewSource(1)))
 
 	if err != nil {
 		t.Errorf("Error %v", err)
 	}
 }
+
+func TestPickDestinationQuarantined(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	rng := rand.New(rand.NewSource(1))
+	token, other := testAddress(10), testAddress(11)
+	sc.AddToken(token, 0, "0-0", 1)
+	sc.partitioning.Move(token, 1)
+	sc.AddToken(other, 1, "1-0", 2)
+	sc.partitioning.Move(other, 2)
+	sc.Quarantine(token, 1)
+
+	// Same-shard and cross-shard transfers from the quarantined partition
+	for _, crossShard := range []float32{0, 1} {
+		sc.crossShardPercentage = crossShard
+		for i := 0; i < 10; i++ {
+			toToken, partition := sc.pickDestination(token, 1, rng)
+			if toToken != other || partition != 2 {
+				t.Fatalf("Picked %v in partition %v", toToken, partition)
+			}
+		}
+	}
+	// Cross-shard transfers to the quarantined partition stay
+	for i := 0; i < 10; i++ {
+		toToken, partition := sc.pickDestination(other, 2, rng)
+		if toToken != other || partition != 2 {
+			t.Fatalf("Picked %v in partition %v", toToken, partition)
+		}
+	}
+}
+
+func TestPickDestinationGovernor(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	sc.governor = partitioning.NewMoveGovernor(0, 0, 1, 0, nil)
+	sc.crossShardPercentage = 1
+	rng := rand.New(rand.NewSource(1))
+	token, neighbour, other := testAddress(10), testAddress(11), testAddress(12)
+	for i, address := range []crypto.Address{token, neighbour, other} {
+		partition := int64(1 + i/2)
+		sc.AddToken(address, 0, "0-"+strconv.Itoa(i), partition)
+		sc.partitioning.Move(address, partition)
+	}
+
+	// Partition 2 emptied: the transfer stays without reserving a move
+	sc.Quarantine(other, 2)
+	toToken, partition := sc.pickDestination(token, 1, rng)
+	if (toToken != token && toToken != neighbour) || partition != 1 {
+		t.Errorf("Picked %v in partition %v", toToken, partition)
+	}
+	if sc.balancePrediction[0] != 0 || sc.balancePrediction[1] != 0 {
+		t.Errorf("Balance predicted %v for a same-shard transfer", sc.balancePrediction)
+	}
+	if !sc.governor.AllowMove(token, 1, 2) {
+		t.Errorf("Move of partition 1 reserved by a same-shard transfer")
+	}
+
+	// Cap of partition 1 reached: suppressed moves are not predicted
+	sc.AddToken(testAddress(13), 0, "0-3", 2)
+	sc.partitioning.Move(testAddress(13), 2)
+	toToken, partition = sc.pickDestination(token, 1, rng)
+	if (toToken != token && toToken != neighbour) || partition != 1 {
+		t.Errorf("Picked %v in partition %v past the cap", toToken, partition)
+	}
+	if sc.balancePrediction[0] != 0 || sc.balancePrediction[1] != 0 {
+		t.Errorf("Balance predicted %v for a suppressed move", sc.balancePrediction)
+	}
+	sc.governor.NewBlock(1)
+	toToken, partition = sc.pickDestination(token, 1, rng)
+	if toToken != testAddress(13) || partition != 2 {
+		t.Errorf("Picked %v in partition %v in a new block", toToken, partition)
+	}
+	if sc.balancePrediction[0] != -1 || sc.balancePrediction[1] != 1 {
+		t.Errorf("Balance predicted %v for a move", sc.balancePrediction)
+	}
+}
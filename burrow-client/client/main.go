@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -23,6 +24,8 @@ import (
 	"github.com/hyperledger/burrow/deploy/def"
 
 	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/lightclient"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
 	log "github.com/sirupsen/logrus"
 )
@@ -57,12 +60,34 @@ type Client struct {
 	signedHeaderCh     chan MoveResponse
 	logs               *utils.Log
 	contractsPerClient int
+	// Partitions the client rebalanced to
+	knownPartitions int64
+	// Contracts created in partition 1 to move together to their partition,
+	// nil unless creating the initial contracts with batchMoves
+	unplaced map[int64][]crypto.Address
+	// Same-shard transfers in flight, nil when sending one tx at a time
+	pipeline *pipeline
+	// Picks the source token of transfers among myTokens
+	sourceDistribution Distribution
+	openLoop           *OpenLoop
+	// Open loop: operations of the account run one at a time while running
+	opLock  sync.Mutex
+	running bool
+	rng     *rand.Rand
+	// Operations left when replaying a trace
+	trace     []TraceOp
+	replaying bool
 }
 
 func NewClient(accountID int, clients map[string][]*def.Client, scalableCoin *ScalableCoin,
 	acc acm.AddressableSigner, logs *utils.Log, contractsPerClient int,
-	signedHeaderCh chan MoveResponse) *Client {
+	signedHeaderCh chan MoveResponse, openLoop *OpenLoop) *Client {
 	clientConns := make(map[string]*def.Client)
+	scalableCoin.AddClient(accountID, acc.GetAddress())
+	var txPipeline *pipeline
+	if scalableCoin.txTracker != nil {
+		txPipeline = newPipeline(scalableCoin.outstandingTxs, scalableCoin.txTimeout)
+	}
 
 	for p := range clients {
 		randClientN := accountID % len(clients[p])
@@ -83,6 +108,13 @@ func NewClient(accountID int, clients map[string][]*def.Client, scalableCoin *Sc
 		signedHeaderCh:     signedHeaderCh,
 		logs:               logs,
 		contractsPerClient: contractsPerClient,
+		knownPartitions:    scalableCoin.NumberPartitions(),
+		sourceDistribution: NewDistribution(scalableCoin.sourceDistribution),
+		openLoop:           openLoop,
+		pipeline:           txPipeline,
+		rng:                rand.New(rand.NewSource(scalableCoin.seed + int64(accountID))),
+		trace:              scalableCoin.trace[accountID],
+		replaying:          scalableCoin.trace != nil,
 	}
 }
 
@@ -115,9 +147,13 @@ func (c *Client) createContract(tx *payload.CallTx, staticContract bool) error {
 	for _, ev := range ex.Events {
 		if ev.Log != nil {
 			addr = c.scalableCoin.extractContractAddress(ev.Log.Data)
-			partition = c.scalableCoin.partitioning.GetHash(*addr)
-			// Should move
-			if partition != 1 {
+			partition = c.scalableCoin.placement.Place(*addr)
+			// Moved with the other contracts of the client
+			if partition != 1 && c.unplaced != nil && !staticContract {
+				c.unplaced[partition] = append(c.unplaced[partition], *addr)
+				partition = 1
+			} else if partition != 1 {
+				// Should move
 				debug("Moving %v to partition %v", tx.Address, partition)
 				isMoving = true
 				moveTo := c.scalableCoin.createMoveTo(*addr, int(partition))
@@ -132,20 +168,22 @@ func (c *Client) createContract(tx *payload.CallTx, staticContract bool) error {
 	if staticContract {
 		c.scalableCoin.AddStaticToken(*addr, partition)
 	} else {
+		c.scalableCoin.AddToken(*addr, c.id, fmt.Sprintf("%d-%d", c.id, len(c.myTokens)), partition)
 		c.myTokens = append(c.myTokens, addr)
 		c.tokenToPartition[*addr] = partition
 		c.scalableCoin.partitioning.Move(*addr, partition)
-		c.scalableCoin.AddToken(*addr, partition)
 	}
 	return nil
 }
 
-func (c *Client) transfer(tx *payload.CallTx, moveToPartition int64) error {
+// transfer sends tx, a transfer or a call of the workload named name, moving
+// its token first if moveToPartition is set
+func (c *Client) transfer(name string, tx *payload.CallTx, moveToPartition int64) error {
 	isMoving := false
 	startTime := time.Now()
 	failed := false
 	defer func() {
-		c.logs.Log("latencies", "%v transfer %d %d %v %v\n", c.id, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), isMoving, failed)
+		c.logs.Log("latencies", "%v %v %d %d %v %v\n", c.id, name, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), isMoving, failed)
 	}()
 
 	fromPartition := c.tokenToPartition[*tx.Address]
@@ -190,7 +228,7 @@ func (c *Client) transfer(tx *payload.CallTx, moveToPartition int64) error {
 	}
 	if ex.Exception != nil {
 		failed = true
-		return fmt.Errorf("Exception executing transfer %v", ex.Exception.Exception)
+		return fmt.Errorf("Exception executing %v %v", name, ex.Exception.Exception)
 	}
 
 	return nil
@@ -202,6 +240,7 @@ type MoveResponse struct {
 	responseChan chan *payload.CallTx
 }
 
+// broadcastMove moves the contract of tx from shard from to shard to
 func (c *Client) broadcastMove(tx *payload.CallTx, from string, to string, static bool) error {
 	if from == to {
 		log.Fatalf("Cannot move to itself: %v, from: %v to: %v", tx.Address, from, to)
@@ -216,6 +255,28 @@ func (c *Client) broadcastMove(tx *payload.CallTx, from string, to string, stati
 		// 	log.Warnf("FROM DIVERGE IN CLIENT: %v %v!", from, c.tokenToPartition[*c.myTokens[0]])
 		// }
 	}
+	startTime := time.Now()
+	m := c.newMove(*tx.Address, from, to)
+	if c.scalableCoin.attackMoves {
+		m.attack = c.prepareAttack(m)
+	}
+	err := c.sendMoveTo(m, tx)
+	if err != nil {
+		c.moveTransition(m, MoveFailed, 0)
+	} else {
+		err = c.finishMove(m, static)
+	}
+	kind := "single"
+	if err == errMoveAborted {
+		kind = "aborted"
+	}
+	c.logs.Log("move-latencies", "%v %v 1 %v %v %d %d %v\n", c.id, kind, from, to, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), err == nil)
+	return err
+}
+
+// sendMoveTo locks the contract of m in its source shard
+func (c *Client) sendMoveTo(m *Move, tx *payload.CallTx) error {
+	from, to := m.From, m.To
 	c.sequencePerPartition[from]++
 	tx.Input.Address = c.myAddress
 	tx.Input.Sequence = c.sequencePerPartition[from]
@@ -245,31 +306,46 @@ func (c *Client) broadcastMove(tx *payload.CallTx, from string, to string, stati
 	// if ex.Exception != nil {
 	// 	return fmt.Errorf("Exception: %v", ex.Exception.Exception)
 	// }
-move2:
-	proofs, err := cli.GetAccountProof(*tx.Address)
-	debug("Got proof: wait for block %v", proofs.AccountProof.Version)
-	if err != nil {
-		return err
-	}
+	c.moveTransition(m, MoveToCommitted, int64(ex.Height))
+	return nil
+}
 
-	waitSignedHeader := make(chan *payload.CallTx)
-	moveResponse := MoveResponse{
-		height:       proofs.StorageProof.Version,
-		chainID:      from,
-		responseChan: waitSignedHeader,
-	}
-	c.signedHeaderCh <- moveResponse
-	select {
-	case <-time.After(3 * time.Minute):
-		log.Infof("Timeout while getting signed header")
-		goto move2
-		// return fmt.Errorf("Error: signed header timeout %v", c.id)
+// finishMove sends move2 with the proofs of the locked contract, once the
+// header of their height is signed. Errors leave the move half-finished.
+func (c *Client) finishMove(m *Move, static bool) error {
+	from, to := m.From, m.To
+	deadline := c.newMoveDeadline(m)
+	for {
+		cli := c.clientConn[from]
+		proofs, err := cli.GetAccountProof(m.Contract)
+		if err != nil {
+			return err
+		}
+		if deadline.expired(proofs.StorageProof.Version) {
+			return c.abortMove(m)
+		}
+		debug("Got proof: wait for block %v", proofs.AccountProof.Version)
+		c.moveTransition(m, ProofFetched, proofs.StorageProof.Version)
+		storageSize, proofSize := utils.ProofSizes(&proofs.StorageProof, &proofs.AccountProof)
+		c.scalableCoin.costModel.Measure(m.Contract, storageSize, proofSize)
 
-	case move2Tx := <-waitSignedHeader:
-		// if !ok {
-		// 	return nil
-		// }
+		move2Tx, err := c.waitSignedHeader(from, proofs.StorageProof.Version, deadline.timeout(signedHeaderTimeout))
+		if err != nil {
+			// Until the deadline, if any
+			log.Infof("[Client %v] Fetching proofs of %v again: %v", c.id, m.Contract, err)
+			continue
+		}
 		debug("Got signed header, sending to %v", to)
+		err = c.scalableCoin.lightClient.VerifyProofs(move2Tx.SignedHeader, &proofs.AccountProof, &proofs.StorageProof)
+		if err != nil {
+			if deadline.set() {
+				log.Warnf("[Client %v] Fetching proofs of %v again: %v", c.id, m.Contract, err)
+				continue
+			}
+			return err
+		}
+		c.attackBeforeMove2(m, move2Tx.SignedHeader, proofs)
+		c.moveTransition(m, HeaderObtained, move2Tx.SignedHeader.Height)
 
 		c.sequencePerPartition[to]++
 		move2Tx.StorageProof = &proofs.StorageProof
@@ -280,21 +356,27 @@ move2:
 			Sequence: c.sequencePerPartition[to],
 		}
 
-		move2Tx.Address = tx.Address
+		contract := m.Contract
+		move2Tx.Address = &contract
 		move2Tx.Fee = 1
 		move2Tx.GasLimit = 4100000000
 
-		txPayload = payload.Payload(move2Tx)
-		env = txs.Enclose(to, txPayload)
+		txPayload := payload.Payload(move2Tx)
+		env := txs.Enclose(to, txPayload)
 		err = env.Sign(c.acc)
 		if err != nil {
 			return err
 		}
 		cli = c.clientConn[to]
-		ex, err = cli.BroadcastEnvelope(env, c.scalableCoin.logger)
+		ex, err := cli.BroadcastEnvelope(env, c.scalableCoin.logger)
 
 		if err != nil {
 			debug("Error sending move2 to %v", to)
+			if deadline.set() {
+				log.Warnf("[Client %v] Error sending move2 of %v, retrying: %v", c.id, contract, err)
+				c.resyncSequence(to)
+				continue
+			}
 			return err
 		}
 		for ex == nil {
@@ -303,9 +385,15 @@ move2:
 		}
 		if ex.Exception != nil {
 			debug("Exception sending move2")
+			if deadline.set() {
+				log.Warnf("[Client %v] Exception sending move2 of %v, retrying: %v", c.id, contract, ex.Exception.Exception)
+				continue
+			}
 			return fmt.Errorf("Exception: %v", ex.Exception.Exception)
 		}
 		c.logs.Log("latencies", "%v move2 %v %v\n", c.id, to, ex.Height)
+		c.moveTransition(m, Move2Committed, int64(ex.Height))
+		c.attackAfterMove2(m, move2Tx.SignedHeader, proofs)
 
 		toInt, err := strconv.Atoi(to)
 		if err != nil {
@@ -314,17 +402,135 @@ move2:
 
 		// log.Infof("MOVED %v from %v to %v", tx.Address, from, to)
 		if !static {
-			c.scalableCoin.partitioning.Move(*tx.Address, int64(toInt))
-			c.tokenToPartition[*tx.Address] = int64(toInt)
+			c.scalableCoin.partitioning.Move(contract, int64(toInt))
+			c.tokenToPartition[contract] = int64(toInt)
+		}
+		c.moveTransition(m, MoveCompleted, int64(ex.Height))
+		return nil
+	}
+}
+
+// accountOp executes approve, transferFrom, allowance and balance operations
+func (c *Client) accountOp(op *Operation) error {
+	isMoving := op.moveToPartition != 0
+	startTime := time.Now()
+	failed := false
+	defer func() {
+		c.logs.Log("latencies", "%v %v %d %d %v %v\n", c.id, op.Name, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), isMoving, failed)
+	}()
+
+	partition, _ := c.scalableCoin.partitioning.Get(*op.Tx.Address)
+	if isMoving {
+		// The spender moves its token next to the approving Account
+		fromPartition := c.tokenToPartition[op.toToken]
+		moveTo := c.scalableCoin.createMoveTo(op.toToken, int(op.moveToPartition))
+		err := c.broadcastMove(moveTo, strconv.Itoa(int(fromPartition)), strconv.Itoa(int(op.moveToPartition)), false)
+		if err != nil {
+			return err
+		}
+		c.scalableCoin.Migrated(op.toToken, fromPartition, op.moveToPartition)
+		partition = op.moveToPartition
+	}
+	partitionStr := strconv.Itoa(int(partition))
+
+	// Read-only, not included in a block
+	if op.Name == "allowance" || op.Name == "balance" {
+		ex, err := c.clientConn[partitionStr].QueryContract(&def.QueryArg{
+			Input:   c.myAddress.String(),
+			Address: op.Tx.Address.String(),
+			Data:    hex.EncodeToString(op.Tx.Data),
+		}, c.scalableCoin.logger)
+		if err != nil {
+			return err
+		}
+		if ex.Exception != nil {
+			failed = true
+			return fmt.Errorf("Exception querying %v: %v", op.Name, ex.Exception.Exception)
 		}
 		return nil
 	}
+
+	c.sequencePerPartition[partitionStr]++
+	op.Tx.Input.Sequence = c.sequencePerPartition[partitionStr]
+	op.Tx.Input.Address = c.myAddress
+
+	env := txs.Enclose(partitionStr, payload.Payload(op.Tx))
+	err := env.Sign(c.acc)
+	if err != nil {
+		return err
+	}
+	ex, err := c.clientConn[partitionStr].BroadcastEnvelope(env, c.scalableCoin.logger)
+	if err != nil {
+		return err
+	}
+	if ex.Exception != nil {
+		failed = true
+		return fmt.Errorf("Exception executing %v: %v", op.Name, ex.Exception.Exception)
+	}
+	if op.Name == "approve" {
+		c.scalableCoin.Approved(*op.Tx.Address, op.spender)
+	} else {
+		c.scalableCoin.TransferredFrom(*op.Tx.Address, c.myAddress)
+	}
+	return nil
+}
+
+// rebalance moves a share of the client tokens to a partition added by a scale
+// out, in a batch per source partition if batchMoves
+func (c *Client) rebalance(partition int64) {
+	toMove := int(c.scalableCoin.scaleOutShare * float64(len(c.myTokens)))
+	bySource := make(map[int64][]crypto.Address)
+	for _, idx := range c.rng.Perm(len(c.myTokens))[:toMove] {
+		token := *c.myTokens[idx]
+		fromPartition := c.tokenToPartition[token]
+		if fromPartition == partition {
+			continue
+		}
+		if c.scalableCoin.batchMoves {
+			bySource[fromPartition] = append(bySource[fromPartition], token)
+			continue
+		}
+		startTime := time.Now()
+		moveTo := c.scalableCoin.createMoveTo(token, int(partition))
+		err := c.broadcastMove(moveTo, strconv.Itoa(int(fromPartition)), strconv.Itoa(int(partition)), false)
+		c.logs.Log("latencies", "%v rebalance %d %d %v\n", c.id, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), err == nil)
+		if err != nil {
+			log.Warnf("[Client %v] Error rebalancing %v to partition %v: %v", c.id, token, partition, err)
+			continue
+		}
+		c.scalableCoin.Migrated(token, fromPartition, partition)
+	}
+
+	for fromPartition, tokens := range bySource {
+		startTime := time.Now()
+		err := c.migrateBatch(tokens, fromPartition, partition)
+		c.logs.Log("latencies", "%v rebalance %d %d %v\n", c.id, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), err == nil)
+	}
 }
 
-func signedHeaderGetter(blockChans []chan *rpcevents.SignedHeadersResult, clients map[string][]*def.Client, getHeader chan MoveResponse) {
+// migrateBatch moves the tokens of the client from fromPartition to
+// toPartition in a batch, the ones moved migrate in the partitioning
+func (c *Client) migrateBatch(tokens []crypto.Address, fromPartition, toPartition int64) error {
+	err := c.broadcastMoveBatch(tokens, strconv.Itoa(int(fromPartition)), strconv.Itoa(int(toPartition)))
+	if err != nil {
+		log.Warnf("[Client %v] Error moving %v tokens from partition %v to %v: %v", c.id, len(tokens), fromPartition, toPartition, err)
+	}
+	for _, token := range tokens {
+		if c.tokenToPartition[token] == toPartition {
+			c.scalableCoin.Migrated(token, fromPartition, toPartition)
+		}
+	}
+	return err
+}
+
+// signedHeaderGetter answers the requests of signed headers, with the headers
+// kept of the last cacheSize blocks of each shard or, for older ones, fetched
+func signedHeaderGetter(blockChans []chan *rpcevents.SignedHeadersResult, clients map[string][]*def.Client, getHeader chan MoveResponse,
+	governor *partitioning.MoveGovernor, txTracker *TxTracker, lightClient *lightclient.LightClient, cacheSize int) {
 	cases := make([]reflect.SelectCase, len(blockChans))
 	mapMutex := sync.RWMutex{}
 	blockGetHeaderMap := make(map[string]map[int64][]chan *payload.CallTx)
+	headerCache := make(map[string]*headerRing)
 	running := true
 
 	go func() {
@@ -332,7 +538,17 @@ func signedHeaderGetter(blockChans []chan *rpcevents.SignedHeadersResult, client
 			select {
 			case get := <-getHeader:
 				mapMutex.Lock()
-				blockGetHeaderMap[get.chainID][get.height] = append(blockGetHeaderMap[get.chainID][get.height], get.responseChan)
+				cache := headerCache[get.chainID]
+				if signedBlock, ok := cache.get(get.height); ok {
+					get.responseChan <- &payload.CallTx{
+						SignedHeader: signedBlock.SignedHeader,
+					}
+				} else if get.height <= cache.last {
+					// Gone by and not kept
+					go fetchSignedHeader(clients[get.chainID][0], get, lightClient)
+				} else {
+					blockGetHeaderMap[get.chainID][get.height] = append(blockGetHeaderMap[get.chainID][get.height], get.responseChan)
+				}
 				mapMutex.Unlock()
 			}
 		}
@@ -342,39 +558,73 @@ func signedHeaderGetter(blockChans []chan *rpcevents.SignedHeadersResult, client
 		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
 		chainID := strconv.Itoa(i + 1)
 		blockGetHeaderMap[chainID] = make(map[int64][]chan *payload.CallTx)
+		headerCache[chainID] = newHeaderRing(cacheSize)
 	}
 	for running {
-		_, selectValue, _ := reflect.Select(cases)
+		partitionIdx, selectValue, _ := reflect.Select(cases)
 		signedBlock := selectValue.Interface().(*rpcevents.SignedHeadersResult)
 		chainID := signedBlock.SignedHeader.ChainID
+		governor.NewBlock(int64(partitionIdx + 1))
+		txTracker.Executed(signedBlock.TxExecutions)
 		debug("Got block from partition: %v %v, len: %v", signedBlock.SignedHeader.ChainID, signedBlock.SignedHeader.Height, len(blockGetHeaderMap[chainID]))
-		mapMutex.RLock()
-		found := false
+		if err := lightClient.VerifyHeader(signedBlock.SignedHeader); err != nil {
+			// Fetched again for the moves waiting, from the last node of the
+			// shard, the stream is from the first
+			log.Warnf("Not using header, fetching it again: %v", err)
+			height := signedBlock.SignedHeader.Height
+			mapMutex.Lock()
+			headerCache[chainID].passed(height)
+			for _, resp := range blockGetHeaderMap[chainID][height] {
+				get := MoveResponse{height: height, chainID: chainID, responseChan: resp}
+				go fetchSignedHeader(clients[chainID][len(clients[chainID])-1], get, lightClient)
+			}
+			delete(blockGetHeaderMap[chainID], height)
+			mapMutex.Unlock()
+			continue
+		}
+		mapMutex.Lock()
+		headerCache[chainID].add(signedBlock)
 		for _, resp := range blockGetHeaderMap[chainID][signedBlock.SignedHeader.Height] {
-			found = true
 			tx := &payload.CallTx{
 				SignedHeader: signedBlock.SignedHeader,
 			}
 			resp <- tx
 		}
-		mapMutex.RUnlock()
-		if found {
-			mapMutex.Lock()
-			delete(blockGetHeaderMap[chainID], signedBlock.SignedHeader.Height)
-			mapMutex.Unlock()
-		}
+		delete(blockGetHeaderMap[chainID], signedBlock.SignedHeader.Height)
+		mapMutex.Unlock()
 	}
 }
 
-func (c *Client) spawnClient(ctx context.Context, experimentCtr chan chan bool) {
-	running := true
-	go func() {
-		<-ctx.Done()
-		running = false
-	}()
+// fetchSignedHeader answers get with the header fetched from client, or nil
+// if it cannot be fetched or verified
+func fetchSignedHeader(client *def.Client, get MoveResponse, lightClient *lightclient.LightClient) {
+	signedBlock, err := utils.FetchSignedHeader(client, get.height)
+	if err != nil {
+		log.Warnf("Error fetching signed header %v of %v: %v", get.height, get.chainID, err)
+		get.responseChan <- nil
+		return
+	}
+	if err := lightClient.VerifyHeader(signedBlock.SignedHeader); err != nil {
+		log.Warnf("Not using header: %v", err)
+		get.responseChan <- nil
+		return
+	}
+	get.responseChan <- &payload.CallTx{
+		SignedHeader: signedBlock.SignedHeader,
+	}
+}
 
-	beginExperiment := make(chan bool)
+// spawnClient creates the client contracts and runs operations each time it
+// receives a context to run with, until ctx is done
+func (c *Client) spawnClient(ctx context.Context, experimentCtr chan chan context.Context) {
+	// Buffered, the next phase can start the client while it stops
+	beginExperiment := make(chan context.Context, 1)
 
+	// Contracts a previous run left locked
+	c.resumeMoves()
+	if c.scalableCoin.batchMoves {
+		c.unplaced = make(map[int64][]crypto.Address)
+	}
 	for contractsPerClient := 0; contractsPerClient < c.contractsPerClient; contractsPerClient++ {
 		tx := c.scalableCoin.createNewAccount()
 		err := c.createContract(tx, false)
@@ -392,56 +642,211 @@ func (c *Client) spawnClient(ctx context.Context, experimentCtr chan chan bool)
 			err = c.createContract(tx, false)
 		}
 	}
+	for partition, contracts := range c.unplaced {
+		c.migrateBatch(contracts, 1, partition)
+	}
+	c.unplaced = nil
 	experimentCtr <- beginExperiment
-	<-beginExperiment
-	log.Infof("[Client %v] Begin transfering", c.id)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case runCtx := <-beginExperiment:
+			log.Infof("[Client %v] Begin transfering", c.id)
+			c.run(runCtx)
+			log.Infof("[Client %v] Stop transfering", c.id)
+		}
+	}
+}
+
+func (c *Client) run(ctx context.Context) {
+	if c.openLoop != nil {
+		c.runOpenLoop(ctx)
+		return
+	}
+	running := true
+	go func() {
+		<-ctx.Done()
+		running = false
+	}()
+
+	// Txs in flight when stopping
+	defer c.drain()
 
 	for running {
-		randomToken := *c.myTokens[rand.Intn(len(c.myTokens))]
-		op := c.scalableCoin.GetOp(randomToken)
-		if op.Name == "newAccount" {
-			err := c.createContract(op.Tx, false)
-			if err != nil {
-				log.Warnf("[Client %v] Error creating contract %v", c.id, err)
+		c.housekeeping()
+		if !c.step() {
+			break
+		}
+	}
+}
+
+// runOpenLoop lets the open loop give operations to the client until ctx is
+// done or the client has no operation left, collecting the txs in flight
+func (c *Client) runOpenLoop(ctx context.Context) {
+	c.opLock.Lock()
+	c.running = true
+	c.opLock.Unlock()
+	c.openLoop.Join(c)
+	defer func() {
+		c.openLoop.Leave(c)
+		c.opLock.Lock()
+		defer c.opLock.Unlock()
+		c.running = false
+		c.drain()
+	}()
+
+	var executed chan struct{}
+	if c.pipeline != nil {
+		executed = c.pipeline.notifyIdle
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-executed:
+		case <-ticker.C:
+		}
+		c.opLock.Lock()
+		running := c.running
+		if running {
+			c.housekeeping()
+		}
+		c.opLock.Unlock()
+		if !running {
+			return
+		}
+	}
+}
+
+// arrive runs the operation of an open-loop arrival once the operations
+// before it in the client let it
+func (c *Client) arrive(arrival time.Time) {
+	c.opLock.Lock()
+	defer c.opLock.Unlock()
+	if !c.running {
+		c.openLoop.Abandon()
+		return
+	}
+	c.housekeeping()
+	c.openLoop.Start(arrival)
+	c.running = c.step()
+}
+
+// housekeeping handles the executed txs of the pipeline and new partitions
+func (c *Client) housekeeping() {
+	if c.pipeline != nil {
+		c.collect()
+	}
+	if nPartitions := c.scalableCoin.NumberPartitions(); nPartitions > c.knownPartitions {
+		log.Infof("[Client %v] Rebalancing to partition %v", c.id, nPartitions)
+		c.drain()
+		c.rebalance(nPartitions)
+		c.knownPartitions = nPartitions
+	}
+}
+
+// step runs one operation, false if the client has none left
+func (c *Client) step() bool {
+	var randomToken crypto.Address
+	var op *Operation
+	if c.replaying {
+		if len(c.trace) == 0 {
+			log.Infof("[Client %v] Trace replayed", c.id)
+			c.openLoop.Done()
+			return false
+		}
+		var ok bool
+		op, ok = c.scalableCoin.ReplayOp(c.trace[0])
+		if !ok {
+			// Token of another client not created yet
+			c.openLoop.Done()
+			time.Sleep(expectedBlockTime)
+			return true
+		}
+		c.trace = c.trace[1:]
+		if op.Name != "newAccount" {
+			randomToken = *op.Tx.Address
+		}
+	} else {
+		if len(c.myTokens) == 0 {
+			log.Warnf("[Client %v] All tokens quarantined", c.id)
+			c.openLoop.Done()
+			return false
+		}
+		randomToken = *c.myTokens[c.sourceDistribution.Pick(c.rng, len(c.myTokens))]
+		op = c.scalableCoin.GetOp(randomToken, c.myAddress, c.rng)
+	}
+	c.scalableCoin.RecordOp(c.id, op)
+	// Calls of the workload go as transfers
+	isTransfer := op.Name == "transfer" || op.spec
+	if c.pipeline != nil && isTransfer && op.moveToPartition == 0 {
+		// Finished when found in a block
+		err := c.sendPipelined(op)
+		if err != nil {
+			log.Warnf("[Client %v] Error sending pipelined transfer %v", c.id, err)
+			c.openLoop.Done()
+		}
+		return true
+	}
+	// Moves and sequences of other operations need the txs in flight done
+	c.drain()
+	if op.Name == "newAccount" && !op.spec {
+		err := c.createContract(op.Tx, false)
+		c.openLoop.Done()
+		if err != nil {
+			log.Warnf("[Client %v] Error creating contract %v", c.id, err)
+			return false
+		}
+	} else if !isTransfer {
+		err := c.accountOp(op)
+		if err != nil {
+			log.Warnf("[Client %v] Error executing %v: %v", c.id, op.Name, err)
+		}
+		c.openLoop.Done()
+	} else {
+		err := c.transfer(op.Name, op.Tx, op.moveToPartition)
+		retry := 1
+		for err != nil && err != errMoveAborted {
+			awaitTime := time.Duration(c.rng.Intn(10)) * expectedBlockTime
+			log.Warnf("[Client %v] Error transfering %v, retrying in %v s", c.id, err, awaitTime)
+			time.Sleep(awaitTime)
+			c.scalableCoin.GetRetryOp(randomToken, op)
+			err = c.transfer(op.Name, op.Tx, op.moveToPartition)
+			if retry > 10 {
+				log.Infof("[Client %v] Gave up", c.id)
+				c.logs.Log("latencies", "%v gaveUp %v\n", c.id, time.Now().UnixNano())
 				break
 			}
-		} else {
-			err := c.transfer(op.Tx, op.moveToPartition)
-			retry := 1
-			for err != nil {
-				awaitTime := time.Duration(rand.Intn(10)) * expectedBlockTime
-				log.Warnf("[Client %v] Error transfering %v, retrying in %v s", c.id, err, awaitTime)
-				time.Sleep(awaitTime)
-				c.scalableCoin.GetRetryOp(randomToken, op)
-				err = c.transfer(op.Tx, op.moveToPartition)
-				if retry > 10 {
-					log.Infof("[Client %v] Gave up", c.id)
-					c.logs.Log("latencies", "%v gaveUp %v\n", c.id, time.Now().UnixNano())
-					break
-				}
-				retry++
-			}
-			if op.moveToPartition != 0 {
-				if c.tokenToPartition[randomToken] != op.moveToPartition {
-					log.Fatalf("c.tokenToPartition[randomToken] !=op.moveToPartition -> %v != %v", c.tokenToPartition[randomToken], op.moveToPartition)
-				}
-				c.scalableCoin.FinishCrossShard(randomToken, op.toToken, c.tokenToPartition[randomToken], op.moveToPartition)
-			} else {
-				c.scalableCoin.FinishSameShard(op.toToken, c.tokenToPartition[randomToken])
+			retry++
+		}
+		if err == errMoveAborted {
+			// Stayed in its source, or quarantined there
+		} else if op.moveToPartition != 0 {
+			if c.tokenToPartition[randomToken] != op.moveToPartition {
+				log.Fatalf("c.tokenToPartition[randomToken] !=op.moveToPartition -> %v != %v", c.tokenToPartition[randomToken], op.moveToPartition)
 			}
+			c.scalableCoin.FinishCrossShard(randomToken, op.toToken, c.tokenToPartition[randomToken], op.moveToPartition)
+		} else {
+			c.scalableCoin.FinishSameShard(op.toToken, c.tokenToPartition[randomToken])
 		}
+		c.openLoop.Done()
 	}
+	return true
 }
 
 func generateClient(wg *sync.WaitGroup, ctx context.Context, clients map[string][]*def.Client, accountID int,
-	scalableCoin *ScalableCoin, logs *utils.Log, contractsPerClient int, signedHeaderCh chan MoveResponse, experimentCtr chan chan bool) {
+	scalableCoin *ScalableCoin, logs *utils.Log, contractsPerClient int, signedHeaderCh chan MoveResponse, experimentCtr chan chan context.Context,
+	openLoop *OpenLoop) {
 	defer wg.Done()
 
 	tmpAccounts := make([]*acm.PrivateAccount, 1)
 	tmpAccounts[0] = acm.GeneratePrivateAccountFromSecret(strconv.Itoa(accountID + 1))
 	acc := acm.SigningAccounts(tmpAccounts)[0]
 
-	c := NewClient(accountID, clients, scalableCoin, acc, logs, contractsPerClient, signedHeaderCh)
+	c := NewClient(accountID, clients, scalableCoin, acc, logs, contractsPerClient, signedHeaderCh, openLoop)
 	waitFor := (time.Duration(accountID) * time.Second) / 50
 	log.Infof("Client %v waiting for %v", accountID, waitFor)
 	time.Sleep(waitFor)
@@ -470,6 +875,10 @@ func main() {
 		}
 	}
 
+	// Log a drawn seed so the run can be repeated
+	if config.Benchmark.Seed == 0 {
+		config.Benchmark.Seed = time.Now().UnixNano()
+	}
 	scalableCoin := NewScalableCoinAPI(&config, logs)
 	err = scalableCoin.CreateContract(clients["1"][0], "1", config.Contracts.Path, defaultAccount[0])
 	checkFatalError(err)
@@ -487,43 +896,86 @@ func main() {
 		}
 	}()
 
-	for partition := 0; partition < int(config.Partitioning.NumberPartitions); partition++ {
+	if share := config.Partitioning.ScaleOut.Share; share < 0 || share > 1 {
+		log.Fatalf("scaleOut.share %v not in [0, 1]", share)
+	}
+	if config.Partitioning.ScaleOut.After > 0 && len(config.Servers) <= int(config.Partitioning.NumberPartitions) {
+		log.Fatalf("No server for shard %v to scale out", config.Partitioning.NumberPartitions+1)
+	}
+	// Servers after the first numberPartitions are only used when scaling out
+	for partition := 0; partition < len(config.Servers); partition++ {
 		chainID := strconv.Itoa(partition + 1)
 
 		blockChans = append(blockChans, make(chan *rpcevents.SignedHeadersResult, 50))
-		go utils.ListenBlockHeaders2(chainID, clients[chainID][0], logs, blockChans[partition])
+		if partition < int(config.Partitioning.NumberPartitions) {
+			go utils.ListenBlockHeaders2(chainID, clients[chainID][0], logs, blockChans[partition])
+		}
 	}
 
-	experimentCtr := make(chan chan bool)
+	experimentCtr := make(chan chan context.Context)
+	config.Benchmark.Clients = phasesClients(&config)
+	log.Infof("Seed: %v", config.Benchmark.Seed)
+	openLoop := NewOpenLoop(&config, logs)
 
 	var wg sync.WaitGroup
 	for cli := 0; cli < config.Benchmark.Clients; cli++ {
 		wg.Add(1)
-		go generateClient(&wg, ctx, clients, cli, scalableCoin, logs, config.Benchmark.MaximumAccounts, signedHeaderCh, experimentCtr)
+		go generateClient(&wg, ctx, clients, cli, scalableCoin, logs, config.Benchmark.MaximumAccounts, signedHeaderCh, experimentCtr, openLoop)
 	}
 
-	go signedHeaderGetter(blockChans, clients, signedHeaderCh)
+	headerCache := config.Benchmark.HeaderCache
+	if headerCache < 0 {
+		log.Fatalf("headerCache %v is negative", headerCache)
+	}
+	if headerCache == 0 {
+		headerCache = defaultHeaderCache
+	}
+	go signedHeaderGetter(blockChans, clients, signedHeaderCh, scalableCoin.governor, scalableCoin.txTracker, scalableCoin.lightClient, headerCache)
 
 	go func() {
-		var beginExperimentCh []chan bool
+		var beginExperimentCh []chan context.Context
 		for cli := 0; cli < config.Benchmark.Clients; cli++ {
 			clientCh := <-experimentCtr
 			beginExperimentCh = append(beginExperimentCh, clientCh)
 		}
 
-		// Send begin experiment
-		for cli := 0; cli < config.Benchmark.Clients; cli++ {
-			beginExperimentCh[cli] <- true
+		// Send begin experiment, phases start clients themselves
+		if len(config.Benchmark.Phases) == 0 {
+			for cli := 0; cli < config.Benchmark.Clients; cli++ {
+				beginExperimentCh[cli] <- ctx
+			}
 		}
 
 		logs.Log("begin-experiment", "%d\n", time.Now().UnixNano())
 		log.Infof("Beggining countdown at %v", time.Now().UnixNano())
+		go openLoop.Run(ctx)
+
+		if config.Partitioning.ScaleOut.After > 0 {
+			go func() {
+				time.Sleep(time.Second * config.Partitioning.ScaleOut.After)
+				// Listen to the new shard before clients move to it
+				newPartition := scalableCoin.NumberPartitions() + 1
+				chainID := strconv.Itoa(int(newPartition))
+				go utils.ListenBlockHeaders2(chainID, clients[chainID][0], logs, blockChans[newPartition-1])
+				partition := scalableCoin.AddPartition()
+				logs.Log("scale-out", "%d %d\n", time.Now().UnixNano(), partition)
+				log.Infof("Scaling out to partition %v", partition)
+			}()
+		}
 
-		timer := time.NewTimer(time.Second * config.Benchmark.ExperimentTime)
-		<-timer.C
+		if len(config.Benchmark.Phases) != 0 {
+			runPhases(ctx, config.Benchmark.Phases, beginExperimentCh, logs)
+		} else {
+			timer := time.NewTimer(time.Second * config.Benchmark.ExperimentTime)
+			<-timer.C
+		}
 		log.Infof("Finishing experiment")
 		cancel()
 	}()
 
 	wg.Wait()
+	openLoop.Summary()
+	if config.Partitioning.SnapshotFile != "" {
+		checkFatalError(partitioning.SaveSnapshot(scalableCoin.partitioning, config.Partitioning.SnapshotFile))
+	}
 }
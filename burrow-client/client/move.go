@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/txs/payload"
+	log "github.com/sirupsen/logrus"
+)
+
+type MoveState int
+
+const (
+	MoveRequested MoveState = iota
+	MoveToCommitted
+	ProofFetched
+	HeaderObtained
+	Move2Committed
+	MoveCompleted
+	MoveFailed
+	// Past the deadline, moved back to the source or left locked in it
+	MoveAborted
+	MoveQuarantined
+)
+
+var moveStateNames = []string{"Requested", "MoveToCommitted", "ProofFetched", "HeaderObtained", "Move2Committed", "Completed", "Failed",
+	"Aborted", "Quarantined"}
+
+// Returned by moves given up after their deadline
+var errMoveAborted = errors.New("Move aborted")
+
+func (s MoveState) String() string {
+	return moveStateNames[s]
+}
+
+func parseMoveState(name string) (MoveState, error) {
+	for state, stateName := range moveStateNames {
+		if stateName == name {
+			return MoveState(state), nil
+		}
+	}
+	return MoveFailed, fmt.Errorf("Unknown move state %v", name)
+}
+
+// Move of a contract from shard From to shard To. The contract is locked in
+// From once moveTo is committed, until move2 is committed in To.
+type Move struct {
+	ID       string
+	Client   int
+	Contract crypto.Address
+	From     string
+	To       string
+	State    MoveState
+	// Height of the last transition, 0 if unknown
+	Height int64
+	// Replays sent with the move, nil if not attacking it
+	attack *moveAttack
+}
+
+// Finished moves do not leave the contract locked
+func (m *Move) Finished() bool {
+	return m.State == MoveCompleted || m.State == MoveFailed || m.State == MoveAborted || m.State == MoveQuarantined
+}
+
+// MoveJournal appends every move transition to a file, synced before the move
+// goes on. Lines are: timestamp id client contract from to state height
+type MoveJournal struct {
+	file *os.File
+	sync.Mutex
+}
+
+func OpenMoveJournal(path string) (*MoveJournal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &MoveJournal{file: file}, nil
+}
+
+// Record appends the current state of m, nothing without a journal
+func (j *MoveJournal) Record(m *Move) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	_, err := fmt.Fprintf(j.file, "%d %v %d %v %v %v %v %d\n", time.Now().UnixNano(), m.ID, m.Client, m.Contract, m.From, m.To, m.State, m.Height)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		log.Fatalf("Error writing move journal: %v", err)
+	}
+}
+
+// LoadUnfinishedMoves reads the journal at path and returns the moves of each
+// client that did not finish, superseded moves of the same contract excluded.
+// A last record cut short by a crash is dropped from the journal.
+func LoadUnfinishedMoves(path string) (map[int][]*Move, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	moves := make(map[string]*Move)
+	lastMove := make(map[crypto.Address]string)
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				log.Warnf("Dropping incomplete last record of %v: %v", path, line)
+				err = file.Truncate(offset)
+				if err != nil {
+					return nil, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(len(line))
+		fields := strings.Fields(line)
+		if len(fields) != 8 {
+			return nil, fmt.Errorf("Malformed line in %v: %v", path, strings.TrimSpace(line))
+		}
+		client, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		contract, err := crypto.AddressFromHexString(fields[3])
+		if err != nil {
+			return nil, err
+		}
+		state, err := parseMoveState(fields[6])
+		if err != nil {
+			return nil, err
+		}
+		height, err := strconv.ParseInt(fields[7], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		moves[fields[1]] = &Move{
+			ID:       fields[1],
+			Client:   client,
+			Contract: contract,
+			From:     fields[4],
+			To:       fields[5],
+			State:    state,
+			Height:   height,
+		}
+		lastMove[contract] = fields[1]
+	}
+
+	unfinished := make(map[int][]*Move)
+	for _, id := range lastMove {
+		if m := moves[id]; !m.Finished() {
+			unfinished[m.Client] = append(unfinished[m.Client], m)
+		}
+	}
+	return unfinished, nil
+}
+
+func (c *Client) newMove(contract crypto.Address, from, to string) *Move {
+	m := &Move{
+		ID:       fmt.Sprintf("%d-%d", c.id, time.Now().UnixNano()),
+		Client:   c.id,
+		Contract: contract,
+		From:     from,
+		To:       to,
+		State:    MoveRequested,
+	}
+	c.scalableCoin.moveJournal.Record(m)
+	return m
+}
+
+func (c *Client) moveTransition(m *Move, state MoveState, height int64) {
+	debug("Move %v of %v: %v -> %v", m.ID, m.Contract, m.State, state)
+	m.State = state
+	m.Height = height
+	c.scalableCoin.moveJournal.Record(m)
+}
+
+// resumeMoves finishes the moves left half-done by a previous run of the client
+func (c *Client) resumeMoves() {
+	for _, m := range c.scalableCoin.unfinishedMoves[c.id] {
+		log.Infof("[Client %v] Resuming move %v of %v from %v to %v in state %v", c.id, m.ID, m.Contract, m.From, m.To, m.State)
+		c.resyncSequence(m.From)
+		c.resyncSequence(m.To)
+
+		switch m.State {
+		case MoveRequested:
+			// Locked if moveTo was committed before stopping
+			acc, err := c.clientConn[m.From].GetAccount(m.Contract)
+			if err != nil || acc == nil || strconv.Itoa(int(acc.ShardID)) != m.To {
+				log.Warnf("[Client %v] moveTo of %v not committed, move %v dropped", c.id, m.Contract, m.ID)
+				c.moveTransition(m, MoveFailed, 0)
+				continue
+			}
+			c.moveTransition(m, MoveToCommitted, 0)
+		case Move2Committed:
+			c.moveTransition(m, MoveCompleted, m.Height)
+			continue
+		}
+		// move2 may have been committed before being recorded
+		if acc, err := c.clientConn[m.To].GetAccount(m.Contract); err == nil && acc != nil && len(acc.Code) != 0 {
+			c.moveTransition(m, MoveCompleted, 0)
+			continue
+		}
+		// Proofs and headers are not kept, fetched again
+		err := c.finishMove(m, true)
+		if err != nil {
+			log.Warnf("[Client %v] Contract %v stranded in %v, move %v: %v", c.id, m.Contract, m.From, m.ID, err)
+		}
+	}
+}
+
+// requestSignedHeader asks for the signed header of chainID at height, sent
+// in a tx on the returned channel, nil if it could not be fetched
+func (c *Client) requestSignedHeader(chainID string, height int64) chan *payload.CallTx {
+	// Buffered, the header getter does not wait for the requester
+	waitSignedHeader := make(chan *payload.CallTx, 1)
+	c.signedHeaderCh <- MoveResponse{
+		height:       height,
+		chainID:      chainID,
+		responseChan: waitSignedHeader,
+	}
+	return waitSignedHeader
+}
+
+// Time waited for a signed header
+const signedHeaderTimeout = 3 * time.Minute
+
+// waitSignedHeader returns a tx with the signed header of chainID at height,
+// waiting at most timeout
+func (c *Client) waitSignedHeader(chainID string, height int64, timeout time.Duration) (*payload.CallTx, error) {
+	waitSignedHeader := c.requestSignedHeader(chainID, height)
+	select {
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("Timeout while getting signed header %v of %v", height, chainID)
+	case tx := <-waitSignedHeader:
+		if tx == nil {
+			return nil, fmt.Errorf("Signed header %v of %v not fetched", height, chainID)
+		}
+		return tx, nil
+	}
+}
+
+// moveDeadline of a move, counted from the height of moveTo, or of the first
+// proofs if unknown, and from when the client started finishing it
+type moveDeadline struct {
+	height int64
+	start  time.Time
+	blocks int64
+	time   time.Duration
+}
+
+func (c *Client) newMoveDeadline(m *Move) *moveDeadline {
+	return &moveDeadline{
+		height: m.Height,
+		start:  time.Now(),
+		blocks: c.scalableCoin.moveDeadlineBlocks,
+		time:   c.scalableCoin.moveDeadlineTime,
+	}
+}
+
+// set if the move is ever aborted
+func (d *moveDeadline) set() bool {
+	return d.blocks > 0 || d.time > 0
+}
+
+// timeout bounds waiting for headers by the time left before the deadline
+func (d *moveDeadline) timeout(timeout time.Duration) time.Duration {
+	if d.time > 0 {
+		if left := d.time - time.Since(d.start); left < timeout {
+			return left
+		}
+	}
+	return timeout
+}
+
+// expired at height of the source
+func (d *moveDeadline) expired(height int64) bool {
+	if d.height == 0 {
+		d.height = height
+	}
+	return (d.blocks > 0 && height-d.height > d.blocks) || (d.time > 0 && time.Since(d.start) > d.time)
+}
+
+// rehome sends the contract of m back to its source shard, which unlocks it
+// only if the shard accepts a moveTo of a locked contract to itself. The
+// contract is read back to check it was unlocked.
+func (c *Client) rehome(m *Move) error {
+	from, err := strconv.Atoi(m.From)
+	if err != nil {
+		return err
+	}
+	env, err := c.signMove(c.scalableCoin.createMoveTo(m.Contract, from), m.From)
+	if err != nil {
+		return err
+	}
+	ex, err := c.clientConn[m.From].BroadcastEnvelope(env, c.scalableCoin.logger)
+	if err != nil {
+		c.resyncSequence(m.From)
+		return err
+	}
+	if ex.Exception != nil {
+		return fmt.Errorf("Exception: %v", ex.Exception.Exception)
+	}
+	acc, err := c.clientConn[m.From].GetAccount(m.Contract)
+	if err != nil {
+		return err
+	}
+	if acc == nil || strconv.Itoa(int(acc.ShardID)) != m.From {
+		return fmt.Errorf("%v still locked in %v", m.Contract, m.From)
+	}
+	return nil
+}
+
+// abortMove gives up m, past its deadline. The contract stays in the source
+// shard for the client and the partitioning, quarantined if not rehomed.
+func (c *Client) abortMove(m *Move) error {
+	startTime := time.Now()
+	// A move2 that failed to answer may have been committed
+	if acc, err := c.clientConn[m.To].GetAccount(m.Contract); err == nil && acc != nil && len(acc.Code) != 0 {
+		to, err := strconv.ParseInt(m.To, 10, 64)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.tokenToPartition[m.Contract]; ok {
+			c.tokenToPartition[m.Contract] = to
+			c.scalableCoin.partitioning.Move(m.Contract, to)
+		}
+		c.moveTransition(m, MoveCompleted, m.Height)
+		return nil
+	}
+	state := MoveQuarantined
+	if c.scalableCoin.moveOnAbort == "rehome" {
+		err := c.rehome(m)
+		if err == nil {
+			state = MoveAborted
+		} else {
+			log.Warnf("[Client %v] Error moving %v back to %v: %v", c.id, m.Contract, m.From, err)
+		}
+	}
+	from, err := strconv.ParseInt(m.From, 10, 64)
+	if err != nil {
+		return err
+	}
+	// Contracts being created are not known yet
+	if _, ok := c.tokenToPartition[m.Contract]; ok {
+		c.tokenToPartition[m.Contract] = from
+		c.scalableCoin.partitioning.Move(m.Contract, from)
+		if state == MoveQuarantined {
+			c.quarantine(m.Contract, from)
+		}
+	}
+	log.Warnf("[Client %v] Move %v of %v from %v to %v aborted: %v", c.id, m.ID, m.Contract, m.From, m.To, state)
+	c.moveTransition(m, state, m.Height)
+	c.logs.Log("latencies", "%v moveAbort %v %v %d %d %v\n", c.id, m.From, m.To, startTime.UnixNano(), time.Since(startTime).Nanoseconds(), state)
+	return errMoveAborted
+}
+
+// quarantine stops using the contract of the client in partition
+func (c *Client) quarantine(contract crypto.Address, partition int64) {
+	for idx, token := range c.myTokens {
+		if *token == contract {
+			c.myTokens = append(c.myTokens[:idx], c.myTokens[idx+1:]...)
+			break
+		}
+	}
+	c.scalableCoin.Quarantine(contract, partition)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testContractA = "00000000000000000000000000000000000000AA"
+	testContractB = "00000000000000000000000000000000000000BB"
+)
+
+func TestLoadUnfinishedMoves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "moves")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "moves.journal")
+
+	for _, tc := range []struct {
+		name    string
+		journal []string
+		// Unfinished move ids of each client
+		unfinished map[int][]string
+		err        string
+	}{
+		{"empty", nil, map[int][]string{}, ""},
+		{"completed", []string{
+			"1 0-1 0 " + testContractA + " 1 2 Requested 0",
+			"2 0-1 0 " + testContractA + " 1 2 MoveToCommitted 10",
+			"3 0-1 0 " + testContractA + " 1 2 Completed 12",
+		}, map[int][]string{}, ""},
+		{"interleaved", []string{
+			"1 0-1 0 " + testContractA + " 1 2 Requested 0",
+			"2 1-1 1 " + testContractB + " 2 1 Requested 0",
+			"3 0-1 0 " + testContractA + " 1 2 MoveToCommitted 10",
+			"4 1-1 1 " + testContractB + " 2 1 MoveToCommitted 11",
+			"5 1-1 1 " + testContractB + " 2 1 Completed 13",
+			"6 0-1 0 " + testContractA + " 1 2 HeaderObtained 10",
+		}, map[int][]string{0: {"0-1"}}, ""},
+		{"superseded", []string{
+			"1 0-1 0 " + testContractA + " 1 2 Requested 0",
+			"2 0-1 0 " + testContractA + " 1 2 MoveToCommitted 10",
+			// Taken over by another move of the contract
+			"3 1-1 1 " + testContractA + " 1 3 Requested 0",
+			"4 1-1 1 " + testContractA + " 1 3 Aborted 20",
+			"5 0-2 0 " + testContractB + " 2 1 Failed 0",
+			"6 0-3 0 " + testContractB + " 2 3 Requested 0",
+		}, map[int][]string{0: {"0-3"}}, ""},
+		{"quarantined", []string{
+			"1 0-1 0 " + testContractA + " 1 2 MoveToCommitted 10",
+			"2 0-1 0 " + testContractA + " 1 2 Quarantined 10",
+		}, map[int][]string{}, ""},
+		{"missing field", []string{"1 0-1 0 " + testContractA + " 1 2 Requested"}, nil, "Malformed line"},
+		{"extra field", []string{"1 0-1 0 " + testContractA + " 1 2 Requested 0 0"}, nil, "Malformed line"},
+		{"unknown state", []string{"1 0-1 0 " + testContractA + " 1 2 Moving 0"}, nil, "Unknown move state"},
+		{"invalid client", []string{"1 0-1 c " + testContractA + " 1 2 Requested 0"}, nil, "invalid syntax"},
+		{"invalid height", []string{"1 0-1 0 " + testContractA + " 1 2 Requested h"}, nil, "invalid syntax"},
+		{"invalid contract", []string{"1 0-1 0 XY 1 2 Requested 0"}, nil, "invalid"},
+	} {
+		var journal string
+		for _, line := range tc.journal {
+			journal += line + "\n"
+		}
+		err := ioutil.WriteFile(path, []byte(journal), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unfinished, err := LoadUnfinishedMoves(path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%v: error %v, expected %v", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tc.name, err)
+			continue
+		}
+		if len(unfinished) != len(tc.unfinished) {
+			t.Errorf("%v: unfinished moves %v, expected %v", tc.name, unfinished, tc.unfinished)
+			continue
+		}
+		for client, expected := range tc.unfinished {
+			var ids []string
+			for _, m := range unfinished[client] {
+				if m.Client != client {
+					t.Errorf("%v: move %v of client %v listed for %v", tc.name, m.ID, m.Client, client)
+				}
+				ids = append(ids, m.ID)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, " ") != strings.Join(expected, " ") {
+				t.Errorf("%v: client %v unfinished moves %v, expected %v", tc.name, client, ids, expected)
+			}
+		}
+	}
+
+	// Nothing is resumed from a malformed journal
+	unfinished, _ := LoadUnfinishedMoves(path)
+	if unfinished != nil {
+		t.Errorf("Unfinished moves after an error: %v", unfinished)
+	}
+	// The last line of a move is its state
+	err = ioutil.WriteFile(path, []byte("1 0-1 0 "+testContractA+" 1 2 HeaderObtained 10\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished, err = LoadUnfinishedMoves(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := unfinished[0][0]
+	if m.State != HeaderObtained || m.Height != 10 || m.From != "1" || m.To != "2" || m.Contract.String() != testContractA {
+		t.Errorf("Wrong move %+v", m)
+	}
+
+	// A record cut short by a crash is dropped, the move resumes from the
+	// previous one and the next records are appended after it
+	complete := "1 0-1 0 " + testContractA + " 1 2 MoveToCommitted 10\n"
+	err = ioutil.WriteFile(path, []byte(complete+"2 0-1 0 "+testContractA+" 1 2 Comp"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unfinished, err = LoadUnfinishedMoves(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unfinished[0]) != 1 || unfinished[0][0].State != MoveToCommitted {
+		t.Errorf("Unfinished moves with a record cut short: %v", unfinished)
+	}
+	journal, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(journal) != complete {
+		t.Errorf("Journal with a record cut short left as %q", journal)
+	}
+
+	// Without a journal nothing is resumed
+	unfinished, err = LoadUnfinishedMoves(filepath.Join(dir, "none"))
+	if unfinished != nil || err != nil {
+		t.Errorf("Moves without a journal: %v %v", unfinished, err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type rateChange struct {
+	after time.Duration
+	rate  float64
+}
+
+// Operations in flight per client by default
+const defaultInFlightPerClient = 10
+
+// OpenLoop generates operation arrivals as a Poisson process, independently of
+// their completion. Each arrival is issued at once on its own goroutine, to
+// the running clients in turn, unless maxInFlight operations are in flight,
+// in which case it is dropped. Operations of one client that need its account
+// wait for each other, the ones starting maxDelay after arriving are late.
+// All methods are safe on a nil OpenLoop (closed-loop benchmark).
+type OpenLoop struct {
+	rate        float64
+	schedule    []rateChange
+	maxDelay    time.Duration
+	maxInFlight int64
+	rng         *rand.Rand
+	logs        *utils.Log
+
+	// Running clients, the next arrival goes to clients[next]
+	clients     []*Client
+	next        int
+	clientsLock sync.Mutex
+
+	issued    int64
+	completed int64
+	inFlight  int64
+	dropped   int64
+	late      int64
+}
+
+// openLoopMaxInFlight is the most operations in flight, 0 without open loop
+func openLoopMaxInFlight(config *config.Config) int {
+	olc := config.Benchmark.OpenLoop
+	if olc.Rate == 0 && len(olc.Schedule) == 0 && !phasesHaveRate(config.Benchmark.Phases) {
+		return 0
+	}
+	if olc.MaxInFlight < 0 {
+		log.Fatalf("openLoop.maxInFlight %v is negative", olc.MaxInFlight)
+	}
+	if olc.MaxInFlight == 0 {
+		return defaultInFlightPerClient * phasesClients(config)
+	}
+	return olc.MaxInFlight
+}
+
+// NewOpenLoop returns nil when no rate is configured
+func NewOpenLoop(config *config.Config, logs *utils.Log) *OpenLoop {
+	olc := config.Benchmark.OpenLoop
+	maxInFlight := openLoopMaxInFlight(config)
+	if maxInFlight == 0 {
+		return nil
+	}
+	ol := &OpenLoop{
+		rate:        olc.Rate,
+		maxDelay:    time.Duration(olc.MaxDelay * float64(time.Second)),
+		maxInFlight: int64(maxInFlight),
+		rng:         rand.New(rand.NewSource(config.Benchmark.Seed)),
+		logs:        logs,
+	}
+	for _, change := range olc.Schedule {
+		ol.schedule = append(ol.schedule, rateChange{after: change.After * time.Second, rate: change.Rate})
+	}
+	// Phases with a rate change it when they begin
+	phaseBegin := time.Duration(0)
+	for _, phase := range config.Benchmark.Phases {
+		if phase.Rate > 0 {
+			ol.schedule = append(ol.schedule, rateChange{after: phaseBegin, rate: phase.Rate})
+		}
+		phaseBegin += phase.Duration * time.Second
+	}
+	sort.SliceStable(ol.schedule, func(i, j int) bool { return ol.schedule[i].after < ol.schedule[j].after })
+	return ol
+}
+
+func phasesHaveRate(phases []config.Phase) bool {
+	for _, phase := range phases {
+		if phase.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Rate in operations per second elapsed after the beginning
+func (ol *OpenLoop) rateAt(elapsed time.Duration) float64 {
+	rate := ol.rate
+	for _, change := range ol.schedule {
+		if change.after <= elapsed {
+			rate = change.rate
+		}
+	}
+	return rate
+}
+
+// Run generates arrivals until ctx is done
+func (ol *OpenLoop) Run(ctx context.Context) {
+	if ol == nil {
+		return
+	}
+	begin := time.Now()
+	nextArrival := begin
+	// Without rate only check again for a schedule change
+	isArrival := false
+	report := time.NewTicker(time.Second)
+	defer report.Stop()
+	for {
+		rate := ol.rateAt(nextArrival.Sub(begin))
+		if rate > 0 {
+			nextArrival = nextArrival.Add(time.Duration(ol.rng.ExpFloat64() / rate * float64(time.Second)))
+			isArrival = true
+		} else {
+			nextArrival = nextArrival.Add(100 * time.Millisecond)
+			isArrival = false
+		}
+	wait:
+		select {
+		case <-ctx.Done():
+			return
+		case <-report.C:
+			ol.logs.Log("open-loop", "%d %f %d %d %d %d %d\n", time.Now().UnixNano(), ol.rateAt(time.Since(begin)),
+				atomic.LoadInt64(&ol.issued), atomic.LoadInt64(&ol.completed), atomic.LoadInt64(&ol.inFlight),
+				atomic.LoadInt64(&ol.dropped), atomic.LoadInt64(&ol.late))
+			goto wait
+		case <-time.After(time.Until(nextArrival)):
+		}
+		if !isArrival {
+			continue
+		}
+		ol.issue(nextArrival)
+	}
+}
+
+// issue runs the operation of arrival on the next client, called by Run only
+func (ol *OpenLoop) issue(arrival time.Time) {
+	if atomic.LoadInt64(&ol.inFlight) >= ol.maxInFlight {
+		atomic.AddInt64(&ol.dropped, 1)
+		return
+	}
+	ol.clientsLock.Lock()
+	var c *Client
+	if len(ol.clients) != 0 {
+		ol.next = (ol.next + 1) % len(ol.clients)
+		c = ol.clients[ol.next]
+	}
+	ol.clientsLock.Unlock()
+	if c == nil {
+		atomic.AddInt64(&ol.dropped, 1)
+		return
+	}
+	atomic.AddInt64(&ol.issued, 1)
+	atomic.AddInt64(&ol.inFlight, 1)
+	go c.arrive(arrival)
+}
+
+// Join makes c receive arrivals
+func (ol *OpenLoop) Join(c *Client) {
+	if ol == nil {
+		return
+	}
+	ol.clientsLock.Lock()
+	defer ol.clientsLock.Unlock()
+	ol.clients = append(ol.clients, c)
+}
+
+// Leave stops giving arrivals to c
+func (ol *OpenLoop) Leave(c *Client) {
+	if ol == nil {
+		return
+	}
+	ol.clientsLock.Lock()
+	defer ol.clientsLock.Unlock()
+	for idx, client := range ol.clients {
+		if client == c {
+			ol.clients = append(ol.clients[:idx], ol.clients[idx+1:]...)
+			return
+		}
+	}
+}
+
+// Start is called when the operation of arrival starts
+func (ol *OpenLoop) Start(arrival time.Time) {
+	if ol.maxDelay > 0 && time.Since(arrival) > ol.maxDelay {
+		atomic.AddInt64(&ol.late, 1)
+	}
+}
+
+// Done finishes an issued operation
+func (ol *OpenLoop) Done() {
+	if ol == nil {
+		return
+	}
+	atomic.AddInt64(&ol.inFlight, -1)
+	atomic.AddInt64(&ol.completed, 1)
+}
+
+// Abandon drops an issued operation its client stopped before starting
+func (ol *OpenLoop) Abandon() {
+	atomic.AddInt64(&ol.inFlight, -1)
+	atomic.AddInt64(&ol.dropped, 1)
+}
+
+func (ol *OpenLoop) Summary() {
+	if ol == nil {
+		return
+	}
+	log.Infof("Open loop: issued %v, completed %v, in flight %v, dropped %v, late %v",
+		atomic.LoadInt64(&ol.issued), atomic.LoadInt64(&ol.completed), atomic.LoadInt64(&ol.inFlight),
+		atomic.LoadInt64(&ol.dropped), atomic.LoadInt64(&ol.late))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenLoopIssue(t *testing.T) {
+	ol := &OpenLoop{maxInFlight: 1}
+	counters := func() (int64, int64, int64) {
+		return atomic.LoadInt64(&ol.issued), atomic.LoadInt64(&ol.inFlight), atomic.LoadInt64(&ol.dropped)
+	}
+
+	// Without running clients arrivals are dropped
+	ol.issue(time.Now())
+	if issued, inFlight, dropped := counters(); issued != 0 || inFlight != 0 || dropped != 1 {
+		t.Errorf("Issued %v, in flight %v, dropped %v without clients", issued, inFlight, dropped)
+	}
+
+	// Issued without waiting for the client, which holds its operations
+	c := &Client{openLoop: ol, running: true}
+	ol.Join(c)
+	c.opLock.Lock()
+	ol.issue(time.Now())
+	if issued, inFlight, dropped := counters(); issued != 1 || inFlight != 1 || dropped != 1 {
+		t.Errorf("Issued %v, in flight %v, dropped %v with a busy client", issued, inFlight, dropped)
+	}
+	// Over maxInFlight
+	ol.issue(time.Now())
+	if issued, inFlight, dropped := counters(); issued != 1 || inFlight != 1 || dropped != 2 {
+		t.Errorf("Issued %v, in flight %v, dropped %v over maxInFlight", issued, inFlight, dropped)
+	}
+
+	// The client stops before running it
+	c.running = false
+	c.opLock.Unlock()
+	for atomic.LoadInt64(&ol.inFlight) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if issued, _, dropped := counters(); issued != 1 || dropped != 3 || atomic.LoadInt64(&ol.completed) != 0 {
+		t.Errorf("Issued %v, dropped %v, completed %v after the client stopped", issued, dropped, ol.completed)
+	}
+
+	ol.Leave(c)
+	ol.issue(time.Now())
+	if issued, _, dropped := counters(); issued != 1 || dropped != 4 {
+		t.Errorf("Issued %v, dropped %v after the client left", issued, dropped)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// phasesClients are the clients to create, the most used by a phase
+func phasesClients(config *config.Config) int {
+	clients := config.Benchmark.Clients
+	for _, phase := range config.Benchmark.Phases {
+		if phase.Clients > clients {
+			clients = phase.Clients
+		}
+	}
+	return clients
+}
+
+// runPhases runs the load profile: in each phase the first phase.Clients
+// clients run and the others are stopped. Returns when the last phase ends.
+func runPhases(ctx context.Context, phases []config.Phase, beginExperimentCh []chan context.Context, logs *utils.Log) {
+	cancels := make([]context.CancelFunc, len(beginExperimentCh))
+	defer func() {
+		for _, cancel := range cancels {
+			if cancel != nil {
+				cancel()
+			}
+		}
+	}()
+
+	for idx, phase := range phases {
+		active := phase.Clients
+		if active == 0 || active > len(beginExperimentCh) {
+			active = len(beginExperimentCh)
+		}
+		for cli := range beginExperimentCh {
+			if cli < active && cancels[cli] == nil {
+				var runCtx context.Context
+				runCtx, cancels[cli] = context.WithCancel(ctx)
+				beginExperimentCh[cli] <- runCtx
+			} else if cli >= active && cancels[cli] != nil {
+				cancels[cli]()
+				cancels[cli] = nil
+			}
+		}
+		logs.Log("phases", "%d %d %d %f\n", time.Now().UnixNano(), idx, active, phase.Rate)
+		log.Infof("Phase %v: %v clients, rate %v for %v s", idx, active, phase.Rate, int64(phase.Duration))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second * phase.Duration):
+		}
+	}
+}
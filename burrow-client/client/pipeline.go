@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/burrow/execution/exec"
+	"github.com/hyperledger/burrow/txs"
+	"github.com/hyperledger/burrow/txs/payload"
+	log "github.com/sirupsen/logrus"
+)
+
+// pendingTx is a transfer sent without waiting for its execution
+type pendingTx struct {
+	op        *Operation
+	hash      string
+	partition string
+	sentAt    time.Time
+	pipeline  *pipeline
+	txe       *exec.TxExecution
+}
+
+// TxTracker finds the pending txs in the blocks and hands them back to the
+// client that sent them, nil when clients do not pipeline txs.
+type TxTracker struct {
+	pending map[string]*pendingTx
+	sync.Mutex
+}
+
+func NewTxTracker() *TxTracker {
+	return &TxTracker{pending: make(map[string]*pendingTx)}
+}
+
+func (t *TxTracker) Track(p *pendingTx) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending[p.hash] = p
+}
+
+func (t *TxTracker) Forget(hash string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.pending, hash)
+}
+
+// Executed is called with the txs of each block
+func (t *TxTracker) Executed(txExecutions []*exec.TxExecution) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	for _, txe := range txExecutions {
+		txHash := string(txe.TxHash)
+		if p, ok := t.pending[txHash]; ok {
+			delete(t.pending, txHash)
+			p.txe = txe
+			p.pipeline.executed(p)
+		}
+	}
+}
+
+// pipeline keeps up to window transfers of a client in flight in each shard
+type pipeline struct {
+	window   int
+	timeout  time.Duration
+	inFlight map[string]map[string]*pendingTx
+	// Executed txs not handled yet, queued without blocking the tracker
+	done     []*pendingTx
+	doneLock sync.Mutex
+	// Signal txs in done to the operation waiting for a slot and, in open
+	// loop, to the client between operations
+	notify     chan struct{}
+	notifyIdle chan struct{}
+}
+
+func newPipeline(window int, timeout time.Duration) *pipeline {
+	return &pipeline{
+		window:     window,
+		timeout:    timeout,
+		inFlight:   make(map[string]map[string]*pendingTx),
+		notify:     make(chan struct{}, 1),
+		notifyIdle: make(chan struct{}, 1),
+	}
+}
+
+// executed queues p, called by the tracker while holding its lock
+func (p *pipeline) executed(tx *pendingTx) {
+	p.doneLock.Lock()
+	p.done = append(p.done, tx)
+	p.doneLock.Unlock()
+	for _, notify := range []chan struct{}{p.notify, p.notifyIdle} {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// takeDone returns the executed txs queued
+func (p *pipeline) takeDone() []*pendingTx {
+	p.doneLock.Lock()
+	defer p.doneLock.Unlock()
+	done := p.done
+	p.done = nil
+	return done
+}
+
+func (p *pipeline) length() int {
+	inFlight := 0
+	for _, txs := range p.inFlight {
+		inFlight += len(txs)
+	}
+	return inFlight
+}
+
+// sendPipelined sends a same-shard transfer, or call of the workload, waiting only for a free slot in
+// the window of its shard
+func (c *Client) sendPipelined(op *Operation) error {
+	partition := strconv.Itoa(int(c.tokenToPartition[*op.Tx.Address]))
+	if partition == "0" {
+		return fmt.Errorf("c.tokenToPartition[tx.Address] == 0")
+	}
+	for len(c.pipeline.inFlight[partition]) >= c.pipeline.window {
+		c.waitCompletion()
+	}
+
+	// Sequences follow the sending order, txs are sent one by one
+	c.sequencePerPartition[partition]++
+	op.Tx.Input.Sequence = c.sequencePerPartition[partition]
+	op.Tx.Input.Address = c.myAddress
+
+	env := txs.Enclose(partition, payload.Payload(op.Tx))
+	err := env.Sign(c.acc)
+	if err != nil {
+		return err
+	}
+	pending := &pendingTx{
+		op:        op,
+		hash:      string(env.Tx.Hash()),
+		partition: partition,
+		sentAt:    time.Now(),
+		pipeline:  c.pipeline,
+	}
+	// Track before sending, the block may arrive before the receipt
+	c.scalableCoin.txTracker.Track(pending)
+	_, err = c.clientConn[partition].BroadcastEnvelopeAsync(env)
+	if err != nil {
+		c.scalableCoin.txTracker.Forget(pending.hash)
+		c.resyncSequence(partition)
+		return err
+	}
+	if c.pipeline.inFlight[partition] == nil {
+		c.pipeline.inFlight[partition] = make(map[string]*pendingTx)
+	}
+	c.pipeline.inFlight[partition][pending.hash] = pending
+	return nil
+}
+
+// resyncSequence reads the sequence of the account in partition, after txs were lost
+func (c *Client) resyncSequence(partition string) {
+	acc, err := c.clientConn[partition].GetAccount(c.myAddress)
+	if err != nil {
+		log.Warnf("[Client %v] Error getting sequence in partition %v: %v", c.id, partition, err)
+		return
+	}
+	c.sequencePerPartition[partition] = acc.Sequence
+}
+
+func (c *Client) completed(p *pendingTx, failed bool) {
+	// Executed right after being given up
+	if _, ok := c.pipeline.inFlight[p.partition][p.hash]; !ok {
+		return
+	}
+	delete(c.pipeline.inFlight[p.partition], p.hash)
+	c.logs.Log("latencies", "%v %v %d %d %v %v\n", c.id, p.op.Name, p.sentAt.UnixNano(), time.Since(p.sentAt).Nanoseconds(), false, failed)
+	partition, _ := strconv.ParseInt(p.partition, 10, 64)
+	c.scalableCoin.FinishSameShard(p.op.toToken, partition)
+	c.openLoop.Done()
+}
+
+// waitCompletion waits for a tx of the pipeline, txs not executed after the
+// timeout are given up and the sequences read again
+func (c *Client) waitCompletion() {
+	select {
+	case <-c.pipeline.notify:
+		c.collect()
+	case <-time.After(c.pipeline.timeout):
+		for partition, pending := range c.pipeline.inFlight {
+			lost := false
+			for _, p := range pending {
+				if time.Since(p.sentAt) > c.pipeline.timeout {
+					c.scalableCoin.txTracker.Forget(p.hash)
+					c.completed(p, true)
+					lost = true
+				}
+			}
+			if lost {
+				log.Warnf("[Client %v] Transfers lost in partition %v", c.id, partition)
+				c.resyncSequence(partition)
+			}
+		}
+	}
+}
+
+// collect handles the txs already executed without waiting
+func (c *Client) collect() {
+	for _, p := range c.pipeline.takeDone() {
+		c.completed(p, p.txe.Exception != nil)
+	}
+}
+
+// drain waits for every tx in flight, before moves and other operations
+func (c *Client) drain() {
+	if c.pipeline == nil {
+		return
+	}
+	for c.pipeline.length() != 0 {
+		c.waitCompletion()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
+	"github.com/hyperledger/burrow/crypto"
+	log "github.com/sirupsen/logrus"
+)
+
+// TargetPolicy chooses, using rng, the partition a cross-shard transfer of token
+// from fromPartition goes to. balancePrediction has the predicted objects of each
+// partition (index partition-1). Accessed is told of every operation of token
+// with counterparty. Called holding the ScalableCoin lock.
+type TargetPolicy interface {
+	Name() string
+	Target(rng *rand.Rand, token crypto.Address, fromPartition int64, balancePrediction []int64) int64
+	Accessed(token, counterparty crypto.Address)
+}
+
+func NewTargetPolicy(policyType string, locality float32, partitioner partitioning.Partitioning) TargetPolicy {
+	switch policyType {
+	case "mostUnbalanced", "":
+		return &MostUnbalancedPolicy{}
+	case "uniform":
+		return &UniformPolicy{}
+	case "locality":
+		return NewLocalityPolicy(locality, partitioner)
+	case "powerOfTwo":
+		return &PowerOfTwoPolicy{}
+	}
+	log.Fatalf("Unknown target policy %v", policyType)
+	return nil
+}
+
+// Random partition other than fromPartition, fromPartition if it is the only one
+func randomOtherPartition(rng *rand.Rand, fromPartition, nPartitions int64) int64 {
+	if nPartitions < 2 {
+		return fromPartition
+	}
+	partition := rng.Int63n(nPartitions-1) + 1
+	if partition >= fromPartition {
+		partition++
+	}
+	return partition
+}
+
+type UniformPolicy struct{}
+
+func (up *UniformPolicy) Name() string { return "uniform" }
+
+func (up *UniformPolicy) Target(rng *rand.Rand, token crypto.Address, fromPartition int64, balancePrediction []int64) int64 {
+	return randomOtherPartition(rng, fromPartition, int64(len(balancePrediction)))
+}
+
+func (up *UniformPolicy) Accessed(token, counterparty crypto.Address) {}
+
+// MostUnbalancedPolicy sends to the partition with less objects, or the second
+// one if the token is already there
+type MostUnbalancedPolicy struct{}
+
+func (mp *MostUnbalancedPolicy) Name() string { return "mostUnbalanced" }
+
+func (mp *MostUnbalancedPolicy) Target(rng *rand.Rand, token crypto.Address, fromPartition int64, balancePrediction []int64) int64 {
+	if len(balancePrediction) < 2 {
+		return fromPartition
+	}
+	mostUnbalancedPartition := 0
+	secondMostUnbalanced := 1
+
+	for idx, bal := range balancePrediction {
+		if bal < balancePrediction[mostUnbalancedPartition] {
+			secondMostUnbalanced = mostUnbalancedPartition
+			mostUnbalancedPartition = idx
+		} else if bal < balancePrediction[secondMostUnbalanced] && idx != mostUnbalancedPartition {
+			secondMostUnbalanced = idx
+		}
+	}
+	if mostUnbalancedPartition == secondMostUnbalanced {
+		log.Fatalf("Should not happen %v %v", mostUnbalancedPartition, secondMostUnbalanced)
+	}
+	if int64(mostUnbalancedPartition+1) == fromPartition {
+		return int64(secondMostUnbalanced + 1)
+	}
+	return int64(mostUnbalancedPartition + 1)
+}
+
+func (mp *MostUnbalancedPolicy) Accessed(token, counterparty crypto.Address) {}
+
+// Counterparties of a token remembered by the locality policy
+const localityHistory = 8
+
+// LocalityPolicy sends, with probability locality, to the partition where most
+// of the recent counterparties of the token are (the most recent one breaking
+// ties), otherwise to a random partition
+type LocalityPolicy struct {
+	locality       float32
+	partitioner    partitioning.Partitioning
+	counterparties map[crypto.Address][]crypto.Address
+}
+
+func NewLocalityPolicy(locality float32, partitioner partitioning.Partitioning) *LocalityPolicy {
+	return &LocalityPolicy{
+		locality:       locality,
+		partitioner:    partitioner,
+		counterparties: make(map[crypto.Address][]crypto.Address),
+	}
+}
+
+func (lp *LocalityPolicy) Name() string { return "locality" }
+
+func (lp *LocalityPolicy) Target(rng *rand.Rand, token crypto.Address, fromPartition int64, balancePrediction []int64) int64 {
+	if rng.Float32() < lp.locality {
+		if partition, ok := lp.counterpartiesPartition(token, fromPartition); ok {
+			return partition
+		}
+	}
+	return randomOtherPartition(rng, fromPartition, int64(len(balancePrediction)))
+}
+
+// counterpartiesPartition is the partition other than fromPartition with most
+// of the recent counterparties of token
+func (lp *LocalityPolicy) counterpartiesPartition(token crypto.Address, fromPartition int64) (int64, bool) {
+	count := make(map[int64]int)
+	best := int64(0)
+	counterparties := lp.counterparties[token]
+	for idx := len(counterparties) - 1; idx >= 0; idx-- {
+		partition, ok := lp.partitioner.Get(counterparties[idx])
+		if !ok || partition == fromPartition {
+			continue
+		}
+		count[partition]++
+		if best == 0 || count[partition] > count[best] {
+			best = partition
+		}
+	}
+	return best, best != 0
+}
+
+func (lp *LocalityPolicy) Accessed(token, counterparty crypto.Address) {
+	if token == counterparty || counterparty == crypto.ZeroAddress {
+		return
+	}
+	for _, k := range []crypto.Address{token, counterparty} {
+		other := counterparty
+		if k == counterparty {
+			other = token
+		}
+		history := append(lp.counterparties[k], other)
+		if len(history) > localityHistory {
+			history = history[1:]
+		}
+		lp.counterparties[k] = history
+	}
+}
+
+// PowerOfTwoPolicy samples two partitions and sends to the one with less objects
+type PowerOfTwoPolicy struct{}
+
+func (pp *PowerOfTwoPolicy) Name() string { return "powerOfTwo" }
+
+func (pp *PowerOfTwoPolicy) Target(rng *rand.Rand, token crypto.Address, fromPartition int64, balancePrediction []int64) int64 {
+	nPartitions := int64(len(balancePrediction))
+	first := randomOtherPartition(rng, fromPartition, nPartitions)
+	second := randomOtherPartition(rng, fromPartition, nPartitions)
+	if balancePrediction[second-1] < balancePrediction[first-1] {
+		return second
+	}
+	return first
+}
+
+func (pp *PowerOfTwoPolicy) Accessed(token, counterparty crypto.Address) {}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/burrow/crypto"
+)
+
+// Tokens are named <owner client>-<index in myTokens>, addresses change
+// between runs but the names do not
+
+// TraceOp is an operation of the ops-trace log, lines are:
+// client timestamp name fromToken toToken moveToPartition
+type TraceOp struct {
+	Name            string
+	From            string
+	To              string
+	MoveToPartition int64
+}
+
+// LoadTrace reads the operations of each client
+func LoadTrace(path string) (map[int][]TraceOp, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	trace := make(map[int][]TraceOp)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("Malformed line in %v: %v", path, scanner.Text())
+		}
+		client, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		moveToPartition, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		trace[client] = append(trace[client], TraceOp{
+			Name:            fields[2],
+			From:            fields[3],
+			To:              fields[4],
+			MoveToPartition: moveToPartition,
+		})
+	}
+	return trace, scanner.Err()
+}
+
+// Should hold the lock
+func (sc *ScalableCoin) nameToken(token crypto.Address) string {
+	if name, ok := sc.tokenName[token]; ok {
+		return name
+	}
+	return "-"
+}
+
+// RecordOp appends op of client to the ops-trace log
+func (sc *ScalableCoin) RecordOp(client int, op *Operation) {
+	if !sc.recordTrace {
+		return
+	}
+	sc.RLock()
+	defer sc.RUnlock()
+	// Spenders are named by their client
+	from, to := "-", "-"
+	switch {
+	case op.spec, op.Name == "transfer", op.Name == "transferFrom":
+		from = sc.nameToken(*op.Tx.Address)
+		to = sc.nameToken(op.toToken)
+	case op.Name == "approve", op.Name == "allowance":
+		from = sc.nameToken(*op.Tx.Address)
+		to = strconv.Itoa(sc.clientID[op.spender])
+	case op.Name == "balance":
+		from = sc.nameToken(*op.Tx.Address)
+	}
+	sc.logs.Log("ops-trace", "%d %d %v %v %v %d\n", client, time.Now().UnixNano(), op.Name, from, to, op.moveToPartition)
+}
+
+// ReplayOp rebuilds a traced operation, false if its tokens do not exist yet
+func (sc *ScalableCoin) ReplayOp(traceOp TraceOp) (*Operation, bool) {
+	if traceOp.Name == "newAccount" {
+		op := sc.NewAccount(crypto.ZeroAddress)
+		return &op, true
+	}
+	sc.RLock()
+	defer sc.RUnlock()
+	from, fromExists := sc.tokenByName[traceOp.From]
+	if !fromExists {
+		return nil, false
+	}
+	switch traceOp.Name {
+	case "approve", "allowance":
+		client, err := strconv.Atoi(traceOp.To)
+		spender, ok := sc.clientAddress[client]
+		if err != nil || !ok {
+			return nil, false
+		}
+		op := &Operation{
+			Name:    traceOp.Name,
+			Tx:      sc.createAccountCall(from, "allowance", spender),
+			spender: spender,
+		}
+		if traceOp.Name == "approve" {
+			op.Tx = sc.createAccountCall(from, "approve", spender, big.NewInt(approvedTokens))
+		}
+		return op, true
+	case "balance":
+		return sc.getBalance(from), true
+	}
+	to, toExists := sc.tokenByName[traceOp.To]
+	if !toExists {
+		return nil, false
+	}
+	if traceOp.Name == "transferFrom" {
+		return sc.transferFromOp(from, to), true
+	}
+	op := &Operation{
+		Name:    traceOp.Name,
+		Tx:      sc.createTransfer(from, to),
+		toToken: to,
+	}
+	// The token may already be there, e.g. placed differently in this run
+	if partition, _ := sc.partitioning.Get(from); partition != traceOp.MoveToPartition {
+		op.moveToPartition = traceOp.MoveToPartition
+	}
+	return op, true
+}
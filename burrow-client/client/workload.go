@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/txs/payload"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// WorkloadSpec declares the calls sent to the objects of a movable contract.
+// The objects are created calling factory (default newAccount) of the deployed
+// contract, which logs the object address, and are moved calling move (default
+// moveTo) with the shard, like the ScalableCoin Accounts.
+type WorkloadSpec struct {
+	// ABI of the objects
+	ABI     string           `yaml:"abi"`
+	Factory string           `yaml:"factory"`
+	Move    string           `yaml:"move"`
+	Methods []WorkloadMethod `yaml:"methods"`
+}
+
+// WorkloadMethod is called on an object of the client with probability
+// proportional to weight
+type WorkloadMethod struct {
+	Name     string        `yaml:"name"`
+	Weight   float64       `yaml:"weight"`
+	Amount   uint64        `yaml:"amount"`
+	GasLimit uint64        `yaml:"gasLimit"`
+	Args     []WorkloadArg `yaml:"args"`
+}
+
+// WorkloadArg generates an argument of a call. The called object moves to the
+// shard of the first token or otherObject argument, the following tokens are
+// picked in that shard.
+type WorkloadArg struct {
+	// token: picked as the destination of a transfer, in another shard with
+	// crossShardPercentage. otherObject: an object owned by another client, a
+	// token if there is none.
+	// client: the address of another client. caller: the calling client.
+	// constant: value, parsed as the ABI type of the argument.
+	// randomInt: uniform in [min, max].
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+	Min   int64  `yaml:"min"`
+	Max   int64  `yaml:"max"`
+}
+
+// Workload is a loaded WorkloadSpec
+type Workload struct {
+	abi     abi.ABI
+	factory string
+	move    string
+	methods []WorkloadMethod
+	// Cumulative weights of the methods
+	weights []float64
+}
+
+func LoadWorkload(path string) (*Workload, error) {
+	specFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := WorkloadSpec{}
+	err = yaml.Unmarshal(specFile, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	abiJSON, err := os.Open(spec.ABI)
+	if err != nil {
+		return nil, err
+	}
+	defer abiJSON.Close()
+	objectABI, err := abi.JSON(abiJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Workload{abi: objectABI, factory: spec.Factory, move: spec.Move}
+	if w.factory == "" {
+		w.factory = "newAccount"
+	}
+	if w.move == "" {
+		w.move = "moveTo"
+	}
+	if _, ok := objectABI.Methods[w.move]; !ok {
+		return nil, fmt.Errorf("Move method %v not in %v", w.move, spec.ABI)
+	}
+
+	total := 0.
+	for _, method := range spec.Methods {
+		abiMethod, ok := objectABI.Methods[method.Name]
+		if !ok {
+			return nil, fmt.Errorf("Method %v not in %v", method.Name, spec.ABI)
+		}
+		if len(method.Args) != len(abiMethod.Inputs) {
+			return nil, fmt.Errorf("Method %v has %v arguments, %v given", method.Name, len(abiMethod.Inputs), len(method.Args))
+		}
+		for idx, arg := range method.Args {
+			argType := abiMethod.Inputs[idx].Type
+			switch arg.Type {
+			case "token", "otherObject", "client", "caller":
+				if argType.T != abi.AddressTy {
+					return nil, fmt.Errorf("Argument %v of %v is not an address", idx, method.Name)
+				}
+			case "randomInt":
+				if argType.T != abi.IntTy && argType.T != abi.UintTy {
+					return nil, fmt.Errorf("Argument %v of %v is not an integer", idx, method.Name)
+				}
+				if arg.Max < arg.Min {
+					return nil, fmt.Errorf("Argument %v of %v: max < min", idx, method.Name)
+				}
+			case "constant":
+				_, err := constantArg(argType, arg.Value)
+				if err != nil {
+					return nil, fmt.Errorf("Argument %v of %v: %v", idx, method.Name, err)
+				}
+			default:
+				return nil, fmt.Errorf("Unknown argument type %v in %v", arg.Type, method.Name)
+			}
+		}
+		if method.Amount == 0 {
+			method.Amount = 1
+		}
+		if method.GasLimit == 0 {
+			method.GasLimit = 4100000000
+		}
+		total += method.Weight
+		w.methods = append(w.methods, method)
+		w.weights = append(w.weights, total)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("No method to call in %v", path)
+	}
+	return w, nil
+}
+
+// intArg converts v to the type packed for the ABI integer t, *big.Int over 64 bits
+func intArg(t abi.Type, v *big.Int) interface{} {
+	if t.Kind == reflect.Ptr {
+		return v
+	}
+	arg := reflect.New(t.Type).Elem()
+	if t.T == abi.UintTy {
+		arg.SetUint(v.Uint64())
+	} else {
+		arg.SetInt(v.Int64())
+	}
+	return arg.Interface()
+}
+
+func constantArg(t abi.Type, value string) (interface{}, error) {
+	switch t.T {
+	case abi.IntTy, abi.UintTy:
+		v, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return nil, fmt.Errorf("Invalid integer %v", value)
+		}
+		return intArg(t, v), nil
+	case abi.BoolTy:
+		return strconv.ParseBool(value)
+	case abi.StringTy:
+		return value, nil
+	case abi.AddressTy:
+		return crypto.AddressFromHexString(value)
+	case abi.BytesTy:
+		return hex.DecodeString(value)
+	}
+	return nil, fmt.Errorf("Unsupported constant type %v", t)
+}
+
+// Random draws of otherClientObject before looking at every object
+const otherObjectTries = 100
+
+// otherClientObject picks an object owned by a client other than caller,
+// lower ranks more often, false if there is none. Should hold the lock.
+func (sc *ScalableCoin) otherClientObject(caller crypto.Address, rng *rand.Rand) (crypto.Address, bool) {
+	callerID := sc.clientID[caller]
+	eligible := func(token crypto.Address) bool {
+		return sc.tokenOwner[token] != callerID && !sc.quarantined[token]
+	}
+	if len(sc.tokens) == 0 {
+		return crypto.ZeroAddress, false
+	}
+	for try := 0; try < otherObjectTries; try++ {
+		token := sc.tokens[sc.destinationDistribution.Pick(rng, len(sc.tokens))]
+		if eligible(token) {
+			return token, true
+		}
+	}
+	var others []crypto.Address
+	for _, token := range sc.tokens {
+		if eligible(token) {
+			others = append(others, token)
+		}
+	}
+	if len(others) == 0 {
+		return crypto.ZeroAddress, false
+	}
+	return others[rng.Intn(len(others))], true
+}
+
+// getWorkloadOp calls a method of the workload on token, should hold the lock
+func (sc *ScalableCoin) getWorkloadOp(token, caller crypto.Address, rng *rand.Rand) *Operation {
+	w := sc.workload
+	toss := rng.Float64() * w.weights[len(w.weights)-1]
+	method := w.methods[sort.Search(len(w.weights), func(i int) bool { return w.weights[i] > toss })]
+	abiMethod := sc.accountABI.Methods[method.Name]
+
+	op := &Operation{Name: method.Name, spec: true}
+	fromPartition, _ := sc.partitioning.Get(token)
+	partition := fromPartition
+	colocated := false
+	// Co-locates token with the first object argument
+	colocate := func(object crypto.Address, objectPartition int64) {
+		colocated = true
+		op.toToken = object
+		partition = objectPartition
+		if objectPartition != fromPartition {
+			op.moveToPartition = objectPartition
+		}
+	}
+
+	args := make([]interface{}, len(method.Args))
+	for idx, arg := range method.Args {
+		argType := arg.Type
+		if argType == "otherObject" {
+			object, ok := sc.otherClientObject(caller, rng)
+			if ok {
+				if !colocated {
+					objectPartition, _ := sc.partitioning.Get(object)
+					colocate(object, objectPartition)
+				}
+				args[idx] = object
+				continue
+			}
+			// No object of another client to use, picked as a token
+			argType = "token"
+		}
+		switch argType {
+		case "token":
+			if colocated && sc.contractsInShard[partition].len() == 0 {
+				// Only quarantined tokens left next to the object
+				args[idx] = op.toToken
+			} else if colocated {
+				args[idx] = sc.GetSameShardRandom(partition, rng)
+			} else {
+				object, objectPartition := sc.pickDestination(token, fromPartition, rng)
+				colocate(object, objectPartition)
+				args[idx] = object
+			}
+		case "client":
+			client, ok := sc.randomSpender(caller, rng)
+			if !ok {
+				log.Fatalf("No other client to call %v with", method.Name)
+			}
+			args[idx] = client
+		case "caller":
+			args[idx] = caller
+		case "constant":
+			args[idx], _ = constantArg(abiMethod.Inputs[idx].Type, arg.Value)
+		case "randomInt":
+			args[idx] = intArg(abiMethod.Inputs[idx].Type, big.NewInt(arg.Min+rng.Int63n(arg.Max-arg.Min+1)))
+		}
+	}
+
+	op.Tx = &payload.CallTx{
+		Input: &payload.TxInput{
+			Amount: method.Amount,
+		},
+		Address:  &token,
+		Fee:      1,
+		GasLimit: method.GasLimit,
+	}
+	txInput, err := abiMethod.Inputs.Pack(args...)
+	fatalError(err)
+	op.Tx.Data = append(abiMethod.Id(), txInput...)
+	if colocated {
+		sc.governor.Access(token, op.toToken)
+		sc.targetPolicy.Accessed(token, op.toToken)
+	}
+	return op
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testObjectABI = `[
+{"type":"function","name":"moveTo","inputs":[{"name":"shardId","type":"uint256"}],"outputs":[]},
+{"type":"function","name":"send","inputs":[{"name":"_to","type":"address"},{"name":"_amount","type":"uint256"}],"outputs":[]},
+{"type":"function","name":"setFlag","inputs":[{"name":"_flag","type":"bool"}],"outputs":[]}
+]`
+
+func TestLoadWorkload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	abiPath := filepath.Join(dir, "object.abi")
+	err = ioutil.WriteFile(abiPath, []byte(testObjectABI), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		methods string
+		err     string
+	}{
+		{"valid", `
+- name: send
+  weight: 2
+  args: [{type: token}, {type: randomInt, min: 1, max: 10}]
+- name: setFlag
+  weight: 1
+  args: [{type: constant, value: "true"}]`, ""},
+		{"unknown method", `
+- name: burn
+  weight: 1`, "Method burn not in"},
+		{"missing argument", `
+- name: send
+  weight: 1
+  args: [{type: token}]`, "has 2 arguments, 1 given"},
+		{"extra argument", `
+- name: setFlag
+  weight: 1
+  args: [{type: constant, value: "true"}, {type: caller}]`, "has 1 arguments, 2 given"},
+		{"address to integer", `
+- name: send
+  weight: 1
+  args: [{type: token}, {type: client}]`, "is not an address"},
+		{"integer to address", `
+- name: send
+  weight: 1
+  args: [{type: randomInt, max: 1}, {type: randomInt, max: 1}]`, "is not an integer"},
+		{"empty range", `
+- name: send
+  weight: 1
+  args: [{type: token}, {type: randomInt, min: 2, max: 1}]`, "max < min"},
+		{"invalid constant", `
+- name: setFlag
+  weight: 1
+  args: [{type: constant, value: "maybe"}]`, "Argument 0 of setFlag"},
+		{"unknown argument type", `
+- name: setFlag
+  weight: 1
+  args: [{type: random}]`, "Unknown argument type random"},
+		{"no weight", `
+- name: setFlag
+  args: [{type: constant, value: "false"}]`, "No method to call"},
+	} {
+		specPath := filepath.Join(dir, "workload.yaml")
+		spec := "abi: " + abiPath + "\nmethods:" + strings.Replace(tc.methods, "\n", "\n  ", -1) + "\n"
+		err := ioutil.WriteFile(specPath, []byte(spec), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := LoadWorkload(specPath)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%v: %v", tc.name, err)
+			} else if len(w.methods) != 2 || w.weights[1] != 3 || w.factory != "newAccount" || w.move != "moveTo" {
+				t.Errorf("%v: wrong workload %+v", tc.name, w)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: error %v, expected %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestOtherClientObject(t *testing.T) {
+	sc := newTestScalableCoin(t, 2)
+	rng := rand.New(rand.NewSource(1))
+	caller, other := testAddress(1), testAddress(2)
+	sc.AddClient(0, caller)
+	sc.AddClient(1, other)
+	if _, ok := sc.otherClientObject(caller, rng); ok {
+		t.Errorf("Object picked without objects")
+	}
+	callerToken, otherToken := testAddress(10), testAddress(11)
+	sc.AddToken(callerToken, 0, "0-0", 1)
+	sc.AddToken(otherToken, 1, "1-0", 2)
+	for i := 0; i < 10; i++ {
+		object, ok := sc.otherClientObject(caller, rng)
+		if !ok || object != otherToken {
+			t.Fatalf("Picked %v %v", object, ok)
+		}
+	}
+	// Only objects of the caller left
+	sc.Quarantine(otherToken, 2)
+	if object, ok := sc.otherClientObject(caller, rng); ok {
+		t.Errorf("Picked %v without objects of other clients", object)
+	}
+	if object, ok := sc.otherClientObject(other, rng); !ok || object != callerToken {
+		t.Errorf("Picked %v %v for the other client", object, ok)
+	}
+}
@@ -20,6 +20,31 @@ type ServerTuple struct {
 	Address string `yaml:"address"`
 }
 
+// Distribution of accesses to tokens: uniform, zipf (exponent) or hotSet,
+// where hotFraction of the tokens receive hotProbability of the accesses
+type Distribution struct {
+	Type           string  `yaml:"type"`
+	Exponent       float64 `yaml:"exponent"`
+	HotFraction    float64 `yaml:"hotFraction"`
+	HotProbability float64 `yaml:"hotProbability"`
+}
+
+// OpMix is the fraction of each Account operation, the rest are transfers
+type OpMix struct {
+	Approve      float32 `yaml:"approve"`
+	TransferFrom float32 `yaml:"transferFrom"`
+	Allowance    float32 `yaml:"allowance"`
+	Balance      float32 `yaml:"balance"`
+}
+
+// Phase of a load profile, 0 clients runs all of them and 0 rate keeps the
+// open-loop rate of the previous phase
+type Phase struct {
+	Duration time.Duration `yaml:"duration"`
+	Clients  int           `yaml:"clients"`
+	Rate     float64       `yaml:"rate"`
+}
+
 type Config struct {
 	Contracts struct {
 		Deploy                 bool   `yaml:"deploy"`
@@ -34,7 +59,12 @@ type Config struct {
 		ContractMappingPath    string `yaml:"contractMappingPath"`
 	}
 	Benchmark struct {
-		Clients        int `yaml:"clients"`
+		Clients int `yaml:"clients"`
+		// Multi-shard replayer: concurrent proof fetches per shard (default 4)
+		ProofWorkers int `yaml:"proofWorkers"`
+		// Replayers: txs sent per partition. Client: same-shard transfers in
+		// flight per account and shard, pipelined when more than 1 or in open
+		// loop (default openLoop.maxInFlight)
 		OutstandingTxs int `yaml:"outstandingTxs"`
 		Timeout        int `yaml:"timeout"`
 
@@ -42,10 +72,75 @@ type Config struct {
 		CreateContractPercentage float32       `yaml:"createContractPercentage"`
 		MaximumAccounts          int           `yaml:"maximumAccounts"`
 		ExperimentTime           time.Duration `yaml:"experimentTime"`
+		// Partition of cross-shard transfers: mostUnbalanced (default), uniform, locality or powerOfTwo
+		TargetPolicy string `yaml:"targetPolicy"`
+		// Probability the locality policy goes to the partition of the recent
+		// counterparties of the token
+		Locality float32 `yaml:"locality"`
+
+		SourceDistribution      Distribution `yaml:"sourceDistribution"`
+		DestinationDistribution Distribution `yaml:"destinationDistribution"`
+
+		OpMix OpMix `yaml:"opMix"`
+		// Workload spec file, the calls of any movable contract instead of the
+		// ScalableCoin transfers and opMix
+		Workload string `yaml:"workload"`
+
+		// Load profile replacing clients and experimentTime, durations in seconds
+		Phases []Phase `yaml:"phases"`
+
+		// Seed of the client random generators (client i uses seed+i), 0 draws one
+		Seed int64 `yaml:"seed"`
+		// Record every operation to the ops-trace log, or replay the operations of a trace
+		RecordTrace bool   `yaml:"recordTrace"`
+		ReplayTrace string `yaml:"replayTrace"`
+		// Append-only journal of the move transitions, moves left half-finished
+		// in it are resumed when the client starts again
+		MoveJournal string `yaml:"moveJournal"`
+		// Moves of several contracts between two shards share one round trip:
+		// moveTo txs in one block, proofs read at one height and one signed header.
+		// Used for the initial contracts of a client, rebalancing and, in the
+		// replayer, the moveTo txs of a block
+		BatchMoves bool `yaml:"batchMoves"`
+		// Test the move validation of the shards: every single move also sends
+		// move2 with stale proofs, a stale header, to a wrong shard and again
+		// once committed. Each is logged to move-attacks as rejected, accepted or
+		// unknown if the shard did not answer; wrongShard is skipped with 2 shards.
+		AttackMoves bool `yaml:"attackMoves"`
+		// Signed headers kept per shard for moves asking for a past height
+		// (default 64), older ones are fetched
+		HeaderCache int `yaml:"headerCache"`
+		// Moves without move2 committed blocks blocks of the source or time
+		// seconds after moveTo are aborted (0 waits forever). The contract is left
+		// out of the workload (onAbort quarantine, default) or moved back to its
+		// source (rehome), if the shard unlocks a contract moved to itself, and
+		// quarantined when moving it back fails.
+		MoveDeadline struct {
+			Blocks  int64         `yaml:"blocks"`
+			Time    time.Duration `yaml:"time"`
+			OnAbort string        `yaml:"onAbort"`
+		} `yaml:"moveDeadline"`
+
+		// Open-loop load: operations arrive as a Poisson process of rate per second,
+		// changed by schedule (after seconds), instead of after the previous one ends
+		OpenLoop struct {
+			Rate     float64 `yaml:"rate"`
+			Schedule []struct {
+				After time.Duration `yaml:"after"`
+				Rate  float64       `yaml:"rate"`
+			} `yaml:"schedule"`
+			// Operations in flight (default 10 per client), more arrivals are dropped
+			MaxInFlight int `yaml:"maxInFlight"`
+			// Operations starting maxDelay seconds after arriving are late
+			MaxDelay float64 `yaml:"maxDelay"`
+		} `yaml:"openLoop"`
 	}
 	Servers []struct {
 		ChainID   string `yaml:"chainID"`
 		Addresses []string
+		// genesis.json of the shard, its headers and move proofs are verified. Startup
+		// fails if the proofs of the shards cannot verify themselves
+		Genesis string `yaml:"genesis"`
 		// Address string `yaml:"address"`
 		// }
 	}
@@ -55,6 +150,40 @@ type Config struct {
 	Partitioning struct {
 		Type             string `yaml:"type"`
 		NumberPartitions int64  `yaml:"numberPartitions"`
+		// METIS partition file, used by type "metis"
+		PartitionFile string `yaml:"partitionFile"`
+		// Co-access counters decay and allowed load over the average (default
+		// 0.1), used by type "coaccess"
+		Decay        float64 `yaml:"decay"`
+		MaxImbalance float64 `yaml:"maxImbalance"`
+		// Initial placement: modulo (replayers default), roundRobin, keccak (client default), consistent or range
+		Placement    string `yaml:"placement"`
+		VirtualNodes int    `yaml:"virtualNodes"`
+		// Largest int key (kitty ID) split by range placement, default 2000000
+		RangeMax int64 `yaml:"rangeMax"`
+		// Moves prefer the objects with the smallest proofs, measured by the
+		// client as it moves them. costFile, the move-costs log of a previous
+		// replay, gives the costs known at startup and makes moves cost-aware too.
+		CostAware bool   `yaml:"costAware"`
+		CostFile  string `yaml:"costFile"`
+		// Where objects are placed at startup and where they are at the end
+		RestoreFile  string `yaml:"restoreFile"`
+		SnapshotFile string `yaml:"snapshotFile"`
+		// Client: add shard numberPartitions+1 (next entry of servers) scaleOut.after
+		// seconds into the experiment and move scaleOut.share (in [0, 1]) of the
+		// contracts to it
+		ScaleOut struct {
+			After time.Duration `yaml:"after"`
+			Share float64       `yaml:"share"`
+		}
+		// Anti ping-pong limits of moves, residence time in seconds
+		Governor struct {
+			MinResidenceOps  int64         `yaml:"minResidenceOps"`
+			MinResidenceTime time.Duration `yaml:"minResidenceTime"`
+			MaxMovesPerBlock int64         `yaml:"maxMovesPerBlock"`
+			// Block size in operations when replaying offline
+			OpsPerBlock int64 `yaml:"opsPerBlock"`
+		}
 	}
 }
 
@@ -0,0 +1,207 @@
+package lightclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/types"
+)
+
+// genesisValidator is a validator of a tendermint (pub_key, power) or burrow
+// (PublicKey, Amount) genesis.json
+type genesisValidator struct {
+	PubKey struct {
+		Type  string `json:"type"`
+		Value []byte `json:"value"`
+	} `json:"pub_key"`
+	Power     json.RawMessage `json:"power"`
+	PublicKey struct {
+		CurveType string
+		PublicKey string
+	}
+	Amount json.RawMessage
+}
+
+func (v *genesisValidator) validator() (*types.Validator, error) {
+	key := v.PubKey.Value
+	power := v.Power
+	if len(key) == 0 {
+		if v.PublicKey.CurveType != "" && strings.ToLower(v.PublicKey.CurveType) != "ed25519" {
+			return nil, fmt.Errorf("Unsupported validator key type %v", v.PublicKey.CurveType)
+		}
+		var err error
+		key, err = hex.DecodeString(v.PublicKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		power = v.Amount
+	} else if v.PubKey.Type != "" && !strings.HasSuffix(v.PubKey.Type, "PubKeyEd25519") {
+		return nil, fmt.Errorf("Unsupported validator key type %v", v.PubKey.Type)
+	}
+	if len(key) != ed25519.PubKeyEd25519Size {
+		return nil, fmt.Errorf("Invalid validator key size %v", len(key))
+	}
+	// Power is a string in tendermint and a number in burrow
+	votingPower, err := strconv.ParseInt(strings.Trim(string(power), `"`), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid validator power %s", power)
+	}
+	var pubKey ed25519.PubKeyEd25519
+	copy(pubKey[:], key)
+	return types.NewValidator(pubKey, votingPower), nil
+}
+
+// LoadValidatorSet reads the validators of the genesis.json at path
+func LoadValidatorSet(path string) (*types.ValidatorSet, error) {
+	genesisFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	genesis := struct {
+		Validators []genesisValidator
+	}{}
+	err = json.Unmarshal(genesisFile, &genesis)
+	if err != nil {
+		return nil, err
+	}
+	if len(genesis.Validators) == 0 {
+		return nil, fmt.Errorf("No validators in %v", path)
+	}
+	var validators []*types.Validator
+	for _, v := range genesis.Validators {
+		validator, err := v.validator()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		validators = append(validators, validator)
+	}
+	return types.NewValidatorSet(validators), nil
+}
+
+// LightClient verifies the signed headers of the shards with the validator
+// sets of their genesis, and the move proofs with the app hash of the headers.
+// The validator sets are not updated, shards must keep their genesis validators.
+type LightClient struct {
+	validators map[string]*types.ValidatorSet
+	logs       *utils.Log
+}
+
+// proofVerifier is a proof that checks itself against the app hash of a header
+type proofVerifier interface {
+	Verify(root []byte) error
+}
+
+// proofsVerifiable fails if the proofs of the shards cannot be checked against
+// an app hash, in which case no move could pass the light client
+func proofsVerifiable() error {
+	for _, proof := range []interface{}{&rpcquery.AccountProof{}, &rpcquery.StorageProof{}} {
+		if _, ok := proof.(proofVerifier); !ok {
+			return fmt.Errorf("Proofs of type %T cannot be verified, remove the genesis of the servers", proof)
+		}
+	}
+	return nil
+}
+
+// New creates a light client for the servers with a genesis, nil if none has
+// one. Headers of the other shards are not verified.
+func New(config *config.Config, logs *utils.Log) (*LightClient, error) {
+	lc := &LightClient{
+		validators: make(map[string]*types.ValidatorSet),
+		logs:       logs,
+	}
+	for _, server := range config.Servers {
+		if server.Genesis == "" {
+			continue
+		}
+		vals, err := LoadValidatorSet(server.Genesis)
+		if err != nil {
+			return nil, err
+		}
+		lc.validators[server.ChainID] = vals
+	}
+	if len(lc.validators) == 0 {
+		return nil, nil
+	}
+	err := proofsVerifiable()
+	if err != nil {
+		return nil, err
+	}
+	return lc, nil
+}
+
+func (lc *LightClient) failed(header *rpcquery.SignedHeader, what string, err error) error {
+	err = fmt.Errorf("Invalid %v of shard %v at %v: %v", what, header.ChainID, header.Height, err)
+	lc.logs.Log("light-client", "%d %v %v %v %v\n", time.Now().UnixNano(), header.ChainID, header.Height, what, err)
+	return err
+}
+
+// VerifyHeader checks that header was committed by more than 2/3 of the
+// voting power of its shard
+func (lc *LightClient) VerifyHeader(header *rpcquery.SignedHeader) error {
+	if lc == nil {
+		return nil
+	}
+	vals, ok := lc.validators[header.ChainID]
+	if !ok {
+		return nil
+	}
+	if header.Header == nil || header.Commit == nil {
+		return lc.failed(header, "header", fmt.Errorf("missing header or commit"))
+	}
+	if !bytes.Equal(header.ValidatorsHash, vals.Hash()) {
+		return lc.failed(header, "header", fmt.Errorf("validators hash %X is not the genesis one", header.ValidatorsHash))
+	}
+	if !bytes.Equal(header.Commit.BlockID.Hash, header.Hash()) {
+		return lc.failed(header, "header", fmt.Errorf("commit of block %X", header.Commit.BlockID.Hash))
+	}
+	err := vals.VerifyCommit(header.ChainID, header.Commit.BlockID, header.Height, header.Commit)
+	if err != nil {
+		return lc.failed(header, "header", err)
+	}
+	return nil
+}
+
+// VerifyProofs checks that the proofs of a moved contract were read at the
+// height of header and are proven by its app hash. The header should be
+// verified already.
+func (lc *LightClient) VerifyProofs(header *rpcquery.SignedHeader, accountProof *rpcquery.AccountProof, storageProof *rpcquery.StorageProof) error {
+	if lc == nil {
+		return nil
+	}
+	if _, ok := lc.validators[header.ChainID]; !ok {
+		return nil
+	}
+	if accountProof == nil || storageProof == nil {
+		return lc.failed(header, "proofs", fmt.Errorf("missing proof"))
+	}
+	if accountProof.Version != header.Height || storageProof.Version != header.Height {
+		return lc.failed(header, "proofs", fmt.Errorf("proofs at %v and %v", accountProof.Version, storageProof.Version))
+	}
+	err := lc.verifyProof(header, "account proof", accountProof)
+	if err != nil {
+		return err
+	}
+	return lc.verifyProof(header, "storage proof", storageProof)
+}
+
+func (lc *LightClient) verifyProof(header *rpcquery.SignedHeader, what string, proof interface{}) error {
+	verifier, ok := proof.(proofVerifier)
+	if !ok {
+		return lc.failed(header, what, fmt.Errorf("proofs of type %T cannot be verified", proof))
+	}
+	err := verifier.Verify(header.AppHash)
+	if err != nil {
+		return lc.failed(header, what, err)
+	}
+	return nil
+}
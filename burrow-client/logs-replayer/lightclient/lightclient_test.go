@@ -0,0 +1,277 @@
+package lightclient
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+	"github.com/tendermint/tendermint/types"
+	"gopkg.in/yaml.v2"
+)
+
+func writeGenesis(t *testing.T, dir, name string, genesis interface{}) string {
+	genesisJSON, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, genesisJSON, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// tendermintGenesis has the keys of pvs with power 10
+func tendermintGenesis(pvs []types.PrivValidator) map[string]interface{} {
+	var validators []map[string]interface{}
+	for _, pv := range pvs {
+		pubKey := pv.GetPubKey().(ed25519.PubKeyEd25519)
+		validators = append(validators, map[string]interface{}{
+			"pub_key": map[string]interface{}{"type": "tendermint/PubKeyEd25519", "value": pubKey[:]},
+			"power":   "10",
+		})
+	}
+	return map[string]interface{}{"chain_id": "1", "validators": validators}
+}
+
+func burrowGenesis(pvs []types.PrivValidator) map[string]interface{} {
+	var validators []map[string]interface{}
+	for _, pv := range pvs {
+		pubKey := pv.GetPubKey().(ed25519.PubKeyEd25519)
+		validators = append(validators, map[string]interface{}{
+			"PublicKey": map[string]interface{}{"CurveType": "ed25519", "PublicKey": strings.ToUpper(hex.EncodeToString(pubKey[:]))},
+			"Amount":    10,
+		})
+	}
+	return map[string]interface{}{"ChainName": "1", "Validators": validators}
+}
+
+func testValidators() []types.PrivValidator {
+	return []types.PrivValidator{types.NewMockPV(), types.NewMockPV(), types.NewMockPV()}
+}
+
+func TestLoadValidatorSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pvs := testValidators()
+
+	tendermintVals, err := LoadValidatorSet(writeGenesis(t, dir, "tendermint.json", tendermintGenesis(pvs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	burrowVals, err := LoadValidatorSet(writeGenesis(t, dir, "burrow.json", burrowGenesis(pvs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tendermintVals.Size() != len(pvs) || tendermintVals.TotalVotingPower() != 30 {
+		t.Errorf("Wrong tendermint validators %v", tendermintVals)
+	}
+	if string(tendermintVals.Hash()) != string(burrowVals.Hash()) {
+		t.Errorf("Same validators with different hashes in tendermint and burrow formats")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		genesis string
+		err     string
+	}{
+		{"no validators", `{"validators": []}`, "No validators"},
+		{"tendermint key type", `{"validators": [{"pub_key": {"type": "tendermint/PubKeySecp256k1", "value": "AA=="}, "power": "10"}]}`, "Unsupported validator key type"},
+		{"tendermint key size", `{"validators": [{"pub_key": {"type": "tendermint/PubKeyEd25519", "value": "AA=="}, "power": "10"}]}`, "Invalid validator key size"},
+		{"tendermint power", `{"validators": [{"pub_key": {"type": "tendermint/PubKeyEd25519", "value": "` +
+			strings.Repeat("A", 43) + `="}, "power": "ten"}]}`, "Invalid validator power"},
+		{"burrow key type", `{"Validators": [{"PublicKey": {"CurveType": "secp256k1", "PublicKey": "AA"}, "Amount": 10}]}`, "Unsupported validator key type"},
+		{"burrow key", `{"Validators": [{"PublicKey": {"CurveType": "ed25519", "PublicKey": "XY"}, "Amount": 10}]}`, "invalid"},
+		{"burrow power", `{"Validators": [{"PublicKey": {"CurveType": "ed25519", "PublicKey": "` +
+			strings.Repeat("AA", 32) + `"}, "Amount": -}]}`, "invalid"},
+	} {
+		path := filepath.Join(dir, "invalid.json")
+		err := ioutil.WriteFile(path, []byte(tc.genesis), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadValidatorSet(path)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: error %v, expected %v", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestVerifyHeader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logs, err := utils.NewLog(dir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pvs := testValidators()
+	vals, err := LoadValidatorSet(writeGenesis(t, dir, "genesis.json", tendermintGenesis(pvs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := &LightClient{validators: map[string]*types.ValidatorSet{"1": vals}, logs: logs}
+
+	// signedHeader at height 5 of shard 1, committed by signers
+	signedHeader := func(validatorsHash []byte, signers []types.PrivValidator) *rpcquery.SignedHeader {
+		header := &types.Header{ChainID: "1", Height: 5, AppHash: []byte("app"), ValidatorsHash: validatorsHash}
+		blockID := types.BlockID{Hash: header.Hash()}
+		voteSet := types.NewVoteSet("1", 5, 0, types.PrecommitType, vals)
+		commit, err := types.MakeCommit(blockID, 5, 0, voteSet, signers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &rpcquery.SignedHeader{Header: header, Commit: commit}
+	}
+
+	err = lc.VerifyHeader(signedHeader(vals.Hash(), pvs))
+	if err != nil {
+		t.Errorf("Valid header rejected: %v", err)
+	}
+	// Needs more than 2/3 of the voting power
+	unsigned := signedHeader(vals.Hash(), pvs)
+	unsigned.Commit.Precommits[0] = nil
+	err = lc.VerifyHeader(unsigned)
+	if err == nil {
+		t.Errorf("Header signed by 2/3 of the voting power accepted")
+	}
+	err = lc.VerifyHeader(signedHeader([]byte("other validators"), pvs))
+	if err == nil {
+		t.Errorf("Header of other validators accepted")
+	}
+	modified := signedHeader(vals.Hash(), pvs)
+	modified.AppHash = []byte("other app")
+	err = lc.VerifyHeader(modified)
+	if err == nil {
+		t.Errorf("Header modified after the commit accepted")
+	}
+	err = lc.VerifyHeader(&rpcquery.SignedHeader{Header: &types.Header{ChainID: "1", Height: 5}})
+	if err == nil {
+		t.Errorf("Header without commit accepted")
+	}
+
+	// Shards without a genesis, or without a light client, are not verified
+	err = lc.VerifyHeader(&rpcquery.SignedHeader{Header: &types.Header{ChainID: "2", Height: 5}})
+	if err != nil {
+		t.Errorf("Header of a shard without genesis rejected: %v", err)
+	}
+	var noLightClient *LightClient
+	err = noLightClient.VerifyHeader(signedHeader([]byte("other validators"), pvs))
+	if err != nil {
+		t.Errorf("Header rejected without light client: %v", err)
+	}
+}
+
+// testProof verifies against root
+type testProof struct {
+	root []byte
+}
+
+func (p *testProof) Verify(root []byte) error {
+	if string(root) != string(p.root) {
+		return fmt.Errorf("root %X", root)
+	}
+	return nil
+}
+
+func TestVerifyProofs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logs, err := utils.NewLog(dir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := &LightClient{validators: map[string]*types.ValidatorSet{"1": nil}, logs: logs}
+	header := &rpcquery.SignedHeader{Header: &types.Header{ChainID: "1", Height: 5, AppHash: []byte("app")}}
+
+	for _, tc := range []struct {
+		name         string
+		accountProof *rpcquery.AccountProof
+		storageProof *rpcquery.StorageProof
+	}{
+		{"missing account proof", nil, &rpcquery.StorageProof{Version: 5}},
+		{"missing storage proof", &rpcquery.AccountProof{Version: 5}, nil},
+		{"account proof height", &rpcquery.AccountProof{Version: 4}, &rpcquery.StorageProof{Version: 5}},
+		{"storage proof height", &rpcquery.AccountProof{Version: 5}, &rpcquery.StorageProof{Version: 6}},
+	} {
+		if lc.VerifyProofs(header, tc.accountProof, tc.storageProof) == nil {
+			t.Errorf("%v: proofs accepted", tc.name)
+		}
+	}
+	// Proofs of the shards are only accepted if they verify themselves
+	err = lc.VerifyProofs(header, &rpcquery.AccountProof{Version: 5}, &rpcquery.StorageProof{Version: 5})
+	if (err == nil) != (proofsVerifiable() == nil) {
+		t.Errorf("Proofs verified %v, verifiable %v", err, proofsVerifiable())
+	}
+	if lc.verifyProof(header, "proof", &testProof{root: []byte("app")}) != nil {
+		t.Errorf("Proof of the app hash rejected")
+	}
+	if lc.verifyProof(header, "proof", &testProof{root: []byte("other app")}) == nil {
+		t.Errorf("Proof of another app hash accepted")
+	}
+	if lc.verifyProof(header, "proof", struct{}{}) == nil {
+		t.Errorf("Proof without Verify accepted")
+	}
+
+	// Shards without a genesis, or without a light client, are not verified
+	other := &rpcquery.SignedHeader{Header: &types.Header{ChainID: "2", Height: 5}}
+	if err := lc.VerifyProofs(other, nil, nil); err != nil {
+		t.Errorf("Proofs of a shard without genesis rejected: %v", err)
+	}
+	var noLightClient *LightClient
+	if err := noLightClient.VerifyProofs(header, nil, nil); err != nil {
+		t.Errorf("Proofs rejected without light client: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lightclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	genesis := writeGenesis(t, dir, "genesis.json", tendermintGenesis(testValidators()))
+
+	var noGenesis config.Config
+	err = yaml.Unmarshal([]byte("servers: [{chainID: \"1\"}]"), &noGenesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc, err := New(&noGenesis, nil)
+	if lc != nil || err != nil {
+		t.Errorf("Light client without genesis: %v %v", lc, err)
+	}
+
+	var withGenesis config.Config
+	err = yaml.Unmarshal([]byte("servers: [{chainID: \"1\", genesis: "+genesis+"}]"), &withGenesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fails at startup if no move could pass it
+	lc, err = New(&withGenesis, nil)
+	if verifiable := proofsVerifiable(); verifiable != nil {
+		if err == nil || err.Error() != verifiable.Error() {
+			t.Errorf("Light client with unverifiable proofs: %v", err)
+		}
+	} else if err != nil || lc == nil || lc.validators["1"] == nil {
+		t.Errorf("Light client with genesis: %v %v", lc, err)
+	}
+}
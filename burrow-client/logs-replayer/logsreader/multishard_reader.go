@@ -118,24 +118,29 @@ func NewTxResponse(methodName string, chainID, originalID int64, amount uint64,
 	}
 
 }
-func (lr *LogsReader) CreateMoveDecidePartitioning(tx *dependencies.TxResponse, partitioning partitioning.Partitioning) []*dependencies.TxResponse {
+
+// CreateMoveDecidePartitioning returns the moves placing the objects of tx in
+// one partition: moveTo and move2 for each object out of it
+func (lr *LogsReader) CreateMoveDecidePartitioning(tx *dependencies.TxResponse, partitioner partitioning.Partitioning) []*dependencies.TxResponse {
 	var txResponses []*dependencies.TxResponse
-	var partitioningObjects []int64
+	var partitioningObjects []partitioning.Key
 	isBirth := false
 
 	if len(tx.OriginalIds) == 3 {
 		// Last one is the kitty id, should not be considered (create in same partition as matron)
 		isBirth = true
-		partitioningObjects = tx.OriginalIds[:2]
+		partitioningObjects = []partitioning.Key{tx.OriginalIds[0], tx.OriginalIds[1]}
 	} else {
-		partitioningObjects = tx.OriginalIds
+		for _, id := range tx.OriginalIds {
+			partitioningObjects = append(partitioningObjects, id)
+		}
 	}
-	shouldMove := !partitioning.IsSame(partitioningObjects...)
+	shouldMove := !partitioner.IsSame(partitioningObjects...)
 
-	partitionToGo := partitioning.WhereToMove(partitioningObjects...)
+	partitionToGo := partitioner.WhereToMove(partitioningObjects...)
 	// Kitty should be born together with mom
 	if isBirth {
-		partitioning.Move(tx.OriginalIds[2], partitionToGo)
+		partitioner.Move(tx.OriginalIds[2], partitionToGo)
 	}
 	// set the partition to go tx
 	tx.PartitionIndex = int(partitionToGo - 1)
@@ -145,8 +150,9 @@ func (lr *LogsReader) CreateMoveDecidePartitioning(tx *dependencies.TxResponse,
 		return nil
 	}
 
-	for _, id := range partitioningObjects {
-		originalPartition, _ := partitioning.Get(id)
+	for _, key := range partitioningObjects {
+		id := key.(int64)
+		originalPartition, _ := partitioner.Get(id)
 		// Should move this id
 		if originalPartition != partitionToGo {
 			// Move input
@@ -159,7 +165,7 @@ func (lr *LogsReader) CreateMoveDecidePartitioning(tx *dependencies.TxResponse,
 			// move from originalPartition to partitionToGo
 			moveToTxResponse := NewTxResponse("moveTo", originalPartition, id, tx.Tx.Input.Amount, txData, partitionToGo)
 			// move2 to partitionToGo
-			partitioning.Move(id, partitionToGo)
+			partitioner.Move(id, partitionToGo)
 			move2TxResponse := NewTxResponse("move2", partitionToGo, id, tx.Tx.Input.Amount, txData, partitionToGo)
 
 			txResponses = append(txResponses, moveToTxResponse)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// METIS graph file written by MetisWrite, partition it with:
+// gpmetis kitties.graph <numberPartitions>
+const metisGraphFile = "kitties.graph"
+
+// Graph of kitty interactions, vertex i is kitty i
+type Graph struct {
+	maxID   int64
+	weights map[int64]int64
+	edges   map[int64]map[int64]int64
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		weights: make(map[int64]int64),
+		edges:   make(map[int64]map[int64]int64),
+	}
+}
+
+func (g *Graph) addVertex(id int64) {
+	if id > g.maxID {
+		g.maxID = id
+	}
+	g.weights[id]++
+}
+
+// AddEdge connects every pair of ids accessed by the same transaction
+func (g *Graph) AddEdge(ids []int64) {
+	for i, a := range ids {
+		// Kitty 0 is not a real kitty, METIS vertices start at 1
+		if a < 1 {
+			continue
+		}
+		g.addVertex(a)
+		for _, b := range ids[i+1:] {
+			if a == b || b < 1 {
+				continue
+			}
+			if g.edges[a] == nil {
+				g.edges[a] = make(map[int64]int64)
+			}
+			if g.edges[b] == nil {
+				g.edges[b] = make(map[int64]int64)
+			}
+			g.edges[a][b]++
+			g.edges[b][a]++
+		}
+	}
+}
+
+// MetisWrite dumps the graph with vertex and edge weights (fmt 011)
+func (g *Graph) MetisWrite() {
+	file, err := os.Create(metisGraphFile)
+	checkFatalError(err)
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	defer writer.Flush()
+
+	nEdges := 0
+	for _, neighbours := range g.edges {
+		nEdges += len(neighbours)
+	}
+	fmt.Fprintf(writer, "%d %d 011\n", g.maxID, nEdges/2)
+	for id := int64(1); id <= g.maxID; id++ {
+		// METIS does not accept vertices without weight
+		fmt.Fprintf(writer, "%d", g.weights[id]+1)
+		for neighbour, weight := range g.edges[id] {
+			fmt.Fprintf(writer, " %d %d", neighbour, weight)
+		}
+		fmt.Fprintf(writer, "\n")
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/hyperledger/burrow/dependencies"
 	"github.com/hyperledger/burrow/rpc/rpcevents"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
 	"github.com/hyperledger/burrow/txs"
 	"gopkg.in/cheggaaa/pb.v1"
 	yaml "gopkg.in/yaml.v2"
@@ -20,12 +21,16 @@ import (
 	"github.com/hyperledger/burrow/deploy/def"
 
 	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/lightclient"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/logsreader"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
 	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
 	log "github.com/sirupsen/logrus"
 )
 
+// Blocks of each shard whose header is kept
+const recentHeadersKept = 64
+
 func checkFatalError(err error) {
 	if err != nil {
 		log.Fatalf("Error: %v", err)
@@ -93,6 +98,9 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 		surplusTxs = append(surplusTxs, 0)
 	}
 	latencyLog := utils.NewLatencyLog()
+	// Bad headers and proofs are only logged, the destination rejects them
+	lightClient, err := lightclient.New(config, logs)
+	checkFatalError(err)
 
 	changeIdsSignAndSendTxBatch := func(txRes []*dependencies.TxResponse) float64 {
 		if len(txRes) == 0 {
@@ -144,6 +152,57 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 
 	moved2TxsToAdd := make(map[int][]*dependencies.TxResponse)
 
+	// addMoveProofs makes the move2 of the object moved by moveTo wait for the
+	// signed header of its proofs
+	addMoveProofs := func(moveTo *dependencies.TxResponse, partitionID int, proofs *rpcquery.AccountProofs) {
+		// Measured costs can be used by later runs (partitioning.costFile)
+		storageSize, proofSize := utils.ProofSizes(&proofs.StorageProof, &proofs.AccountProof)
+		logs.Log("move-costs", "%d %d %d\n", moveTo.OriginalIds[0], storageSize, proofSize)
+		// Save that I need signed header
+		dependencyGraph.AddFieldsToMove2(moveTo.OriginalIds[0], shouldGetSignedHeader, partitionID, proofs)
+	}
+
+	// Headers of the last blocks, for proofs fetched after theirs went by
+	recentHeaders := make([]map[int64]*rpcquery.SignedHeader, len(blockChans))
+	for i := range recentHeaders {
+		recentHeaders[i] = make(map[int64]*rpcquery.SignedHeader)
+	}
+	// attachSignedHeader gives the move2 txs waiting for the header of
+	// partitionID at its height to their partitions
+	attachSignedHeader := func(partitionID int, signedHeader *rpcquery.SignedHeader) {
+		txsToMod, ok := shouldGetSignedHeader[partitionID][signedHeader.Height]
+		if !ok {
+			return
+		}
+		headerErr := lightClient.VerifyHeader(signedHeader)
+		for _, txToMod := range txsToMod {
+			err := headerErr
+			if err == nil {
+				err = lightClient.VerifyProofs(signedHeader, txToMod.Tx.AccountProof, txToMod.Tx.StorageProof)
+			}
+			if err != nil {
+				// Sent anyway, skipping it would block the txs depending on it
+				log.Warnf("move2 of %v to partition %v carries bad proofs: %v", txToMod.OriginalIds, txToMod.PartitionIndex+1, err)
+			}
+			txToMod.Tx.SignedHeader = signedHeader
+			// Send Tx
+			// sendTxsPerPartition = append(sendTxsPerPartition, txToMod)
+			moved2TxsToAdd[txToMod.PartitionIndex] = append(moved2TxsToAdd[txToMod.PartitionIndex], txToMod)
+			// log.Infof("Sending move2 to partition %v", txToMod.PartitionIndex+1)
+		}
+		delete(shouldGetSignedHeader[partitionID], signedHeader.Height)
+	}
+
+	var chainIDs []string
+	for _, server := range config.Servers {
+		chainIDs = append(chainIDs, server.ChainID)
+	}
+	proofWorkers := config.Benchmark.ProofWorkers
+	if proofWorkers == 0 {
+		proofWorkers = defaultProofWorkers
+	}
+	proofPool := NewProofPool(clients, chainIDs, proofWorkers)
+
 	for running {
 		// var sendTxs []*dependencies.TxResponse
 		// Clean some stuff
@@ -151,10 +210,35 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 
 		moveToExecuted := 0
 		move2Executed := 0
-
-		// Select a block from channels, get channel id and block
-		partitionID, selectValue, _ := reflect.Select(cases)
-		signedBlock := selectValue.Interface().(*rpcevents.SignedHeadersResult)
+		// moveTo txs of the block, their proofs are fetched together if batchMoves
+		var batchedMoveTos []*dependencies.TxResponse
+
+		// Select a block from channels, get channel id and block. Proofs are
+		// fetched and handled meanwhile.
+		var partitionID int
+		var signedBlock *rpcevents.SignedHeadersResult
+		for signedBlock == nil {
+			chosen, selectValue, _ := reflect.Select(append(cases[:len(cases):len(cases)], proofPool.cases()...))
+			if chosen < len(cases) {
+				partitionID = chosen
+				signedBlock = selectValue.Interface().(*rpcevents.SignedHeadersResult)
+				break
+			}
+			result := proofPool.selected(chosen-len(cases), selectValue)
+			if result == nil {
+				continue
+			}
+			checkFatalError(result.err)
+			logs.Log("proofs-partition-"+chainIDs[result.partitionID], "%d %d %d\n", result.waited.Nanoseconds(), result.took.Nanoseconds(), time.Now().UnixNano())
+			result.done(result.proofs, result.heights)
+			for height := range result.heights {
+				if signedHeader, ok := recentHeaders[result.partitionID][height]; ok {
+					attachSignedHeader(result.partitionID, signedHeader)
+				}
+			}
+		}
+		recentHeaders[partitionID][signedBlock.SignedHeader.Height] = signedBlock.SignedHeader
+		delete(recentHeaders[partitionID], signedBlock.SignedHeader.Height-recentHeadersKept)
 
 		for _, tx := range moved2TxsToAdd[partitionID] {
 			sendTxsPerPartition = append(sendTxsPerPartition, tx)
@@ -163,16 +247,7 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 		delete(moved2TxsToAdd, partitionID)
 
 		// If we are waiting for a header
-		if txsToMod, ok := shouldGetSignedHeader[partitionID][signedBlock.SignedHeader.Height]; ok {
-			for _, txToMod := range txsToMod {
-				txToMod.Tx.SignedHeader = signedBlock.SignedHeader
-				// Send Tx
-				// sendTxsPerPartition = append(sendTxsPerPartition, txToMod)
-				moved2TxsToAdd[txToMod.PartitionIndex] = append(moved2TxsToAdd[txToMod.PartitionIndex], txToMod)
-				// log.Infof("Sending move2 to partition %v", txToMod.PartitionIndex+1)
-			}
-			delete(shouldGetSignedHeader[partitionID], signedBlock.SignedHeader.Height)
-		}
+		attachSignedHeader(partitionID, signedBlock.SignedHeader)
 		timeGotBlockAt := time.Now().UnixNano()
 		// Go trough received transactions
 		for _, tx := range signedBlock.TxExecutions {
@@ -200,17 +275,18 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 						}
 					}
 				} else if sentTx.MethodName == "moveTo" {
-					// Ids are changed from here on
-					// Get proofs to partition issuing move
-					toPartition := sentTx.ChainID
-					// log.Infof("Partition %v ADDR %v getting proof", toPartition, sentTx.Tx.Address)
-					// TODO: How to parallelise this
-					proofs, err := clients[toPartition][0].GetAccountProof(*sentTx.Tx.Address)
-					// log.Infof("GOT things for account %v %v %v", sentTx.Tx.Address, proofs.AccountProof.Version, proofs.StorageProof.Version)
-					checkFatalError(err)
-					// Save that I need signed header
-					dependencyGraph.AddFieldsToMove2(sentTx.OriginalIds[0], shouldGetSignedHeader, partitionID, proofs)
 					moveToExecuted++
+					if config.Benchmark.BatchMoves {
+						// Proofs fetched after the block, with the other moves from here
+						batchedMoveTos = append(batchedMoveTos, sentTx)
+					} else {
+						// Ids are changed from here on
+						// Get proofs to partition issuing move
+						moveTo, sourceID := sentTx, partitionID
+						proofPool.Fetch(partitionID, *sentTx.Tx.Address, func(proofs *rpcquery.AccountProofs) {
+							addMoveProofs(moveTo, sourceID, proofs)
+						})
+					}
 				} else if sentTx.MethodName == "move2" {
 					move2Executed++
 				}
@@ -227,6 +303,22 @@ func clientEmitter(config *config.Config, logs *utils.Log, contractsMap []*crypt
 				log.Warnf("TX NOT SENT BUT RECEIVED!")
 			}
 		}
+		if len(batchedMoveTos) != 0 {
+			// The moveTo txs freed together were sent in one batch, their proofs
+			// are read at one height so the move2 txs wait for one signed header
+			fetchStart := time.Now()
+			var addresses []crypto.Address
+			for _, moveTo := range batchedMoveTos {
+				addresses = append(addresses, *moveTo.Tx.Address)
+			}
+			moveTos, sourceID, chainID, blockTime := batchedMoveTos, partitionID, signedBlock.SignedHeader.ChainID, signedBlock.SignedHeader.Time.UnixNano()
+			proofPool.FetchBatch(partitionID, addresses, func(proofs []*rpcquery.AccountProofs, heights map[int64]bool) {
+				for i, moveTo := range moveTos {
+					addMoveProofs(moveTo, sourceID, proofs[i])
+				}
+				logs.Log("move-batches-partition-"+chainID, "%d %d %d %d\n", len(moveTos), len(heights), time.Since(fetchStart).Nanoseconds(), blockTime)
+			})
+		}
 
 		outstandingTxs[partitionID] = len(signedBlock.TxExecutions) + surplusTxs[partitionID]
 		surplusTxs[partitionID] = 0
@@ -304,6 +396,10 @@ func main() {
 	checkFatalError(err)
 	err = yaml.Unmarshal(configFile, &config)
 	checkFatalError(err)
+	// Moves are decided before replaying, for the shards there are from the start
+	if config.Partitioning.ScaleOut.After > 0 {
+		log.Fatalf("scaleOut is only supported by the client")
+	}
 
 	logs, err := utils.NewLog(config.Logs.Dir)
 	checkFatalError(err)
@@ -322,18 +418,23 @@ func main() {
 	logsReader.Advance(2)
 	dependencyGraph := dependencies.NewDependencies()
 	var readyToSendTxs []*dependencies.TxResponse
-	var partitioning = partitioning.GetPartitioning(&config)
+	partitioner := partitioning.GetPartitioning(&config, logs)
 	bar := pb.StartNew(5203957)
 
 	g := NewGraph()
 	for tx := range txsChan {
 		g.AddEdge(tx.OriginalIds)
-		readyTxs := dependencyGraph.AddDependencyWithMoves(tx, partitioning)
+		readyTxs := dependencyGraph.AddDependencyWithMoves(tx, partitioner)
 		readyToSendTxs = append(readyToSendTxs, readyTxs...)
 		bar.Add(1)
 	}
 	g.MetisWrite()
 	bar.Finish()
+	// Moves are decided in memory, the final placement is known before replaying
+	if config.Partitioning.SnapshotFile != "" {
+		checkFatalError(partitioning.SaveSnapshot(partitioner, config.Partitioning.SnapshotFile))
+		log.Infof("Saved partitioning to %v", config.Partitioning.SnapshotFile)
+	}
 	log.Infof("Ready to send %v txs", len(readyToSendTxs))
 
 	for part, c := range config.Servers {
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/deploy/def"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+)
+
+// Proof fetchers per shard by default
+const defaultProofWorkers = 4
+
+// Times the proofs of a batch are fetched again to read them at one height
+const batchProofTries = 3
+
+type proofRequest struct {
+	partitionID int
+	addresses   []crypto.Address
+	// Called by the replay loop with the proofs of addresses and their heights
+	done     func(proofs []*rpcquery.AccountProofs, heights map[int64]bool)
+	queuedAt time.Time
+}
+
+type proofResult struct {
+	*proofRequest
+	proofs  []*rpcquery.AccountProofs
+	heights map[int64]bool
+	err     error
+	// Waiting for a worker and fetching
+	waited time.Duration
+	took   time.Duration
+}
+
+// ProofPool fetches the proofs of moved objects with a bounded number of
+// workers per shard, spread over the addresses of the shard. Requests are
+// queued without blocking the replay loop, which receives the results.
+type ProofPool struct {
+	requests []chan *proofRequest
+	pending  [][]*proofRequest
+	results  chan *proofResult
+}
+
+func NewProofPool(clients map[string][]*def.Client, chainIDs []string, workers int) *ProofPool {
+	p := &ProofPool{
+		requests: make([]chan *proofRequest, len(chainIDs)),
+		pending:  make([][]*proofRequest, len(chainIDs)),
+		results:  make(chan *proofResult, workers*len(chainIDs)),
+	}
+	for partitionID, chainID := range chainIDs {
+		p.requests[partitionID] = make(chan *proofRequest)
+		for w := 0; w < workers; w++ {
+			go p.worker(clients[chainID][w%len(clients[chainID])], p.requests[partitionID])
+		}
+	}
+	return p
+}
+
+func (p *ProofPool) worker(client *def.Client, requests <-chan *proofRequest) {
+	for req := range requests {
+		start := time.Now()
+		proofs, heights, err := utils.ProofsAtOneHeight(client, req.addresses, batchProofTries)
+		p.results <- &proofResult{
+			proofRequest: req,
+			proofs:       proofs,
+			heights:      heights,
+			err:          err,
+			waited:       start.Sub(req.queuedAt),
+			took:         time.Since(start),
+		}
+	}
+}
+
+// Fetch queues the proofs of address in partitionID, done is called with them
+func (p *ProofPool) Fetch(partitionID int, address crypto.Address, done func(proofs *rpcquery.AccountProofs)) {
+	p.FetchBatch(partitionID, []crypto.Address{address}, func(proofs []*rpcquery.AccountProofs, _ map[int64]bool) {
+		done(proofs[0])
+	})
+}
+
+// FetchBatch queues the proofs of addresses in partitionID, read at one height
+// if possible by one worker, done is called with them and their heights
+func (p *ProofPool) FetchBatch(partitionID int, addresses []crypto.Address, done func(proofs []*rpcquery.AccountProofs, heights map[int64]bool)) {
+	p.pending[partitionID] = append(p.pending[partitionID], &proofRequest{
+		partitionID: partitionID,
+		addresses:   addresses,
+		done:        done,
+		queuedAt:    time.Now(),
+	})
+}
+
+// cases receive the results and send the first pending request of each shard
+func (p *ProofPool) cases() []reflect.SelectCase {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(p.results)}}
+	for partitionID, pending := range p.pending {
+		sendCase := reflect.SelectCase{Dir: reflect.SelectSend}
+		// Without a channel the case is never chosen
+		if len(pending) != 0 {
+			sendCase.Chan = reflect.ValueOf(p.requests[partitionID])
+			sendCase.Send = reflect.ValueOf(pending[0])
+		}
+		cases = append(cases, sendCase)
+	}
+	return cases
+}
+
+// selected handles the chosen case of cases, returning the result received
+func (p *ProofPool) selected(chosen int, value reflect.Value) *proofResult {
+	if chosen == 0 {
+		return value.Interface().(*proofResult)
+	}
+	p.pending[chosen-1] = p.pending[chosen-1][1:]
+	return nil
+}
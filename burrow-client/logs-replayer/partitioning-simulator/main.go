@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/hyperledger/burrow/dependencies"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/logsreader"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/partitioning"
+	"github.com/enriquefynn/sharding-runner/burrow-client/logs-replayer/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// Log the shard load every sampleEvery transactions
+const sampleEvery = 10000
+
+func checkFatalError(err error) {
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+type simulator struct {
+	nPartitions  int64
+	partitioning partitioning.Partitioning
+	logs         *utils.Log
+
+	txs           int64
+	crossShardTxs int64
+	movesPerKitty map[int64]int64
+	// Txs executed in each shard since the last sample
+	txsInShard map[int64]int64
+}
+
+// Objects seen for the first time (trace starting mid-history) use the placement
+func (s *simulator) addUnknown(ids []int64) {
+	for _, id := range ids {
+		if _, exists := s.partitioning.Get(id); !exists {
+			s.partitioning.Add(id)
+		}
+	}
+}
+
+func (s *simulator) process(logsReader *logsreader.LogsReader, tx *dependencies.TxResponse) {
+	s.txs++
+	if tx.MethodName == "createPromoKitty" {
+		partition := s.partitioning.Add(tx.OriginalIds[0])
+		s.txsInShard[partition]++
+		return
+	}
+	if tx.MethodName == "giveBirth" {
+		// The newborn is placed with the matron
+		s.addUnknown(tx.OriginalIds[:2])
+	} else {
+		s.addUnknown(tx.OriginalIds)
+	}
+
+	moves := logsReader.CreateMoveDecidePartitioning(tx, s.partitioning)
+	s.txsInShard[int64(tx.PartitionIndex+1)]++
+	if len(moves) != 0 {
+		s.crossShardTxs++
+	}
+	for _, move := range moves {
+		if move.MethodName == "moveTo" {
+			s.movesPerKitty[move.OriginalIds[0]]++
+		}
+	}
+}
+
+// sample logs, per shard, the txs executed since the last sample and the objects
+func (s *simulator) sample() {
+	elements := s.partitioning.GetElementsInEachPart()
+	s.logs.Log("shard-load", "%d", s.txs)
+	for p := int64(1); p <= s.nPartitions; p++ {
+		s.logs.Log("shard-load", " %d %d", s.txsInShard[p], elements[p])
+		s.txsInShard[p] = 0
+	}
+	s.logs.Log("shard-load", "\n")
+	s.logs.Log("cross-shard-ratio", "%d %d %f\n", s.txs, s.crossShardTxs, float64(s.crossShardTxs)/float64(s.txs))
+}
+
+func (s *simulator) report() {
+	// Histogram: number of moves, number of kitties
+	histogram := make(map[int64]int64)
+	totalMoves := int64(0)
+	for _, moves := range s.movesPerKitty {
+		histogram[moves]++
+		totalMoves += moves
+	}
+	var nMoves []int64
+	for moves := range histogram {
+		nMoves = append(nMoves, moves)
+	}
+	sort.Slice(nMoves, func(i, j int) bool { return nMoves[i] < nMoves[j] })
+	for _, moves := range nMoves {
+		s.logs.Log("moves-per-kitty", "%d %d\n", moves, histogram[moves])
+	}
+
+	log.Infof("Transactions: %v, cross-shard: %v (%.4f), moves: %v, kitties moved: %v",
+		s.txs, s.crossShardTxs, float64(s.crossShardTxs)/float64(s.txs), totalMoves, len(s.movesPerKitty))
+	log.Infof("Balance: %v", s.partitioning.GetElementsInEachPart())
+	if governor := partitioning.GetGovernor(s.partitioning); governor != nil {
+		log.Infof("Suppressed moves: %+v", governor.Counters())
+	}
+}
+
+func main() {
+	config := config.Config{}
+	configFile, err := ioutil.ReadFile(os.Args[1])
+	checkFatalError(err)
+	err = yaml.Unmarshal(configFile, &config)
+	checkFatalError(err)
+
+	logs, err := utils.NewLog(config.Logs.Dir)
+	checkFatalError(err)
+	defer logs.Flush()
+
+	logsReader := logsreader.CreateLogsReader(config.Contracts.ReplayTransactionsPath, config.Contracts.CKABI, config.Contracts.KittyABI)
+	// Skip contracts creation, as the multi-shard replayer
+	logsReader.Advance(2)
+	txsChan := logsReader.LogsLoader()
+
+	s := &simulator{
+		nPartitions:   config.Partitioning.NumberPartitions,
+		partitioning:  partitioning.GetPartitioning(&config, logs),
+		logs:          logs,
+		movesPerKitty: make(map[int64]int64),
+		txsInShard:    make(map[int64]int64),
+	}
+	log.Infof("Simulating %v partitioning with %v partitions", config.Partitioning.Type, config.Partitioning.NumberPartitions)
+	for tx := range txsChan {
+		s.process(logsReader, tx)
+		if s.txs%sampleEvery == 0 {
+			s.sample()
+		}
+	}
+	s.sample()
+	s.report()
+	if config.Partitioning.SnapshotFile != "" {
+		checkFatalError(partitioning.SaveSnapshot(s.partitioning, config.Partitioning.SnapshotFile))
+	}
+}
@@ -0,0 +1,151 @@
+package partitioning
+
+import "math"
+
+// Counters decayed below this are forgotten
+const minCoAccess = 1e-3
+
+// Load allowed over the average by default
+const defaultMaxImbalance = 0.1
+
+type coAccessCounter struct {
+	value      float64
+	lastUpdate int64
+}
+
+// CoAccessPartitioning keeps decaying counters of which objects are accessed
+// together and moves objects towards the partition holding most of their
+// partners, as long as the destination stays within maxImbalance of the
+// average load, or one object over it.
+type CoAccessPartitioning struct {
+	*HashPartitioning
+	// Every decision multiplies the counters by decay
+	decay        float64
+	maxImbalance float64
+	clock        int64
+	coAccess     map[Key]map[Key]*coAccessCounter
+}
+
+// NewCoAccessPartitioning creates the partitioning, decay 0 means counters
+// never decay and maxImbalance 0 is the default
+func NewCoAccessPartitioning(nPartitions int64, placement Placement, decay, maxImbalance float64) *CoAccessPartitioning {
+	if decay <= 0 || decay > 1 {
+		decay = 1
+	}
+	if maxImbalance <= 0 {
+		maxImbalance = defaultMaxImbalance
+	}
+	return &CoAccessPartitioning{
+		HashPartitioning: NewHashPartitioning(nPartitions, placement),
+		decay:            decay,
+		maxImbalance:     maxImbalance,
+		coAccess:         make(map[Key]map[Key]*coAccessCounter),
+	}
+}
+
+func (cp *CoAccessPartitioning) decayed(c *coAccessCounter) float64 {
+	return c.value * math.Pow(cp.decay, float64(cp.clock-c.lastUpdate))
+}
+
+func (cp *CoAccessPartitioning) touch(a, b Key) {
+	if cp.coAccess[a] == nil {
+		cp.coAccess[a] = make(map[Key]*coAccessCounter)
+	}
+	c, ok := cp.coAccess[a][b]
+	if !ok {
+		c = &coAccessCounter{}
+		cp.coAccess[a][b] = c
+	}
+	c.value = cp.decayed(c) + 1
+	c.lastUpdate = cp.clock
+}
+
+// Record co-access between all keys
+func (cp *CoAccessPartitioning) record(keys ...Key) {
+	cp.clock++
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			if a == b {
+				continue
+			}
+			cp.touch(a, b)
+			cp.touch(b, a)
+		}
+	}
+}
+
+// affinity of k to each partition, ignoring the keys being co-located
+func (cp *CoAccessPartitioning) affinity(k Key, keys []Key) map[int64]float64 {
+	aff := make(map[int64]float64)
+	for neighbour, c := range cp.coAccess[k] {
+		value := cp.decayed(c)
+		if value < minCoAccess {
+			delete(cp.coAccess[k], neighbour)
+			continue
+		}
+		if contains(keys, neighbour) {
+			continue
+		}
+		if partition, exists := cp.get(neighbour); exists {
+			aff[partition] += value
+		}
+	}
+	return aff
+}
+
+func contains(keys []Key, k Key) bool {
+	for _, key := range keys {
+		if key == k {
+			return true
+		}
+	}
+	return false
+}
+
+// WhereToMove chooses, among the partitions of keys, the one that keeps most
+// co-accesses local without exceeding the load bound
+func (cp *CoAccessPartitioning) WhereToMove(keys ...Key) int64 {
+	cp.Lock()
+	defer cp.Unlock()
+	defer cp.record(keys...)
+
+	averageLoad := float64(len(cp.partitionMap)) / float64(cp.nPartitions)
+	maxLoad := math.Max((1+cp.maxImbalance)*averageLoad, averageLoad+1)
+
+	affinities := make([]map[int64]float64, len(keys))
+	partitions := make([]int64, len(keys))
+	for i, k := range keys {
+		partition, exists := cp.get(k)
+		if !exists {
+			panic("Should exist")
+		}
+		partitions[i] = partition
+		affinities[i] = cp.affinity(k, keys)
+	}
+
+	moveToPartition := int64(0)
+	bestGain := 0.0
+	for _, candidate := range partitions {
+		moved := int64(0)
+		gain := 0.0
+		for i, partition := range partitions {
+			if partition != candidate {
+				moved++
+				gain += affinities[i][candidate] - affinities[i][partition]
+			}
+		}
+		if float64(cp.elements(candidate)+moved) > maxLoad {
+			continue
+		}
+		if moveToPartition == 0 || gain > bestGain ||
+			(gain == bestGain && cp.elements(candidate) < cp.elements(moveToPartition)) {
+			moveToPartition = candidate
+			bestGain = gain
+		}
+	}
+	// Every candidate breaks the load bound, go to the least loaded
+	if moveToPartition == 0 {
+		moveToPartition = cp.whereToMove(keys...)
+	}
+	return moveToPartition
+}
@@ -0,0 +1,153 @@
+package partitioning
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Logger is satisfied by utils.Log
+type Logger interface {
+	Log(logName, format string, args ...interface{})
+}
+
+// CostModel gives the cost of moving an object and if it is known
+type CostModel interface {
+	MoveCost(k Key) (int64, bool)
+}
+
+// MeasuredCostModel keeps the last measured cost of each object: the size of
+// its storage proof plus the size of its account proof. All methods are safe
+// on a nil model, which knows no cost.
+type MeasuredCostModel struct {
+	costs map[Key]int64
+	total int64
+	sync.RWMutex
+}
+
+func NewMeasuredCostModel() *MeasuredCostModel {
+	return &MeasuredCostModel{costs: make(map[Key]int64)}
+}
+
+// NewFileCostModel reads the move-costs log written by the multi-shard
+// replayer, lines are: kittyID storageSize proofSize
+func NewFileCostModel(costFile string) (*MeasuredCostModel, error) {
+	file, err := os.Open(costFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mc := NewMeasuredCostModel()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Malformed line in %v: %v", costFile, scanner.Text())
+		}
+		var values [3]int64
+		for i, field := range fields {
+			values[i], err = strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		mc.Measure(values[0], int(values[1]), int(values[2]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mc, nil
+}
+
+// Measure records the proof sizes of a move of k, replacing its last cost
+func (mc *MeasuredCostModel) Measure(k Key, storageSize, proofSize int) {
+	if mc == nil {
+		return
+	}
+	mc.Lock()
+	defer mc.Unlock()
+	cost := int64(storageSize + proofSize)
+	mc.total += cost - mc.costs[k]
+	mc.costs[k] = cost
+}
+
+// MoveCost of unmeasured objects is the average cost
+func (mc *MeasuredCostModel) MoveCost(k Key) (int64, bool) {
+	if mc == nil {
+		return 0, false
+	}
+	mc.RLock()
+	defer mc.RUnlock()
+	if cost, ok := mc.costs[k]; ok {
+		return cost, true
+	}
+	if len(mc.costs) == 0 {
+		return 0, false
+	}
+	return mc.total / int64(len(mc.costs)), false
+}
+
+// CostAwarePartitioning moves the cheapest objects: among the partitions of
+// the keys it picks the one minimizing the cost of the keys that have to move,
+// ties are decided by the wrapped partitioning
+type CostAwarePartitioning struct {
+	Partitioning
+	costModel CostModel
+	logger    Logger
+	sync.Mutex
+}
+
+func NewCostAwarePartitioning(partitioning Partitioning, costModel CostModel, logger Logger) *CostAwarePartitioning {
+	return &CostAwarePartitioning{
+		Partitioning: partitioning,
+		costModel:    costModel,
+		logger:       logger,
+	}
+}
+
+func (cp *CostAwarePartitioning) moveCost(to int64, keys []Key) int64 {
+	cost := int64(0)
+	for _, k := range keys {
+		if partition, _ := cp.Get(k); partition != to {
+			c, _ := cp.costModel.MoveCost(k)
+			cost += c
+		}
+	}
+	return cost
+}
+
+func (cp *CostAwarePartitioning) WhereToMove(keys ...Key) int64 {
+	cp.Lock()
+	defer cp.Unlock()
+
+	moveToPartition := cp.Partitioning.WhereToMove(keys...)
+	minCost := cp.moveCost(moveToPartition, keys)
+	for _, k := range keys {
+		candidate, _ := cp.Get(k)
+		if cost := cp.moveCost(candidate, keys); cost < minCost {
+			moveToPartition = candidate
+			minCost = cost
+		}
+	}
+	if cp.logger != nil && !cp.IsSame(keys...) {
+		cp.logger.Log("move-cost", "%d %d %v\n", moveToPartition, minCost, keys)
+	}
+	return moveToPartition
+}
+
+// GetCostModel returns the measured cost model of the partitioning, nil if it
+// is not cost-aware
+func GetCostModel(partitioning Partitioning) *MeasuredCostModel {
+	if gp, ok := partitioning.(*GovernedPartitioning); ok {
+		partitioning = gp.Partitioning
+	}
+	if cp, ok := partitioning.(*CostAwarePartitioning); ok {
+		mc, _ := cp.costModel.(*MeasuredCostModel)
+		return mc
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package partitioning
+
+import (
+	"sync"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+)
+
+const (
+	moveAllowed = iota
+	suppressedResidence
+	suppressedCap
+)
+
+type residence struct {
+	ops   int64
+	since time.Time
+}
+
+// GovernorCounters are the moves suppressed by reason, forced moves were
+// suppressed for every destination but had to happen to co-locate objects
+type GovernorCounters struct {
+	Residence int64
+	Cap       int64
+	Forced    int64
+}
+
+// MoveGovernor avoids objects bouncing between shards: an object must stay
+// minResidenceOps operations and minResidenceTime in a shard before moving
+// again, and each shard emits at most maxMovesPerBlock moves per block.
+// All methods are safe on a nil governor, which allows every move.
+type MoveGovernor struct {
+	minResidenceOps  int64
+	minResidenceTime time.Duration
+	maxMovesPerBlock int64
+	// Without blocks (offline replay) a block is opsPerBlock operations
+	opsPerBlock int64
+
+	ops          int64
+	residences   map[Key]*residence
+	movesInBlock map[int64]int64
+	counters     GovernorCounters
+	logger       Logger
+	sync.Mutex
+}
+
+func NewMoveGovernor(minResidenceOps int64, minResidenceTime time.Duration, maxMovesPerBlock, opsPerBlock int64, logger Logger) *MoveGovernor {
+	return &MoveGovernor{
+		minResidenceOps:  minResidenceOps,
+		minResidenceTime: minResidenceTime,
+		maxMovesPerBlock: maxMovesPerBlock,
+		opsPerBlock:      opsPerBlock,
+		residences:       make(map[Key]*residence),
+		movesInBlock:     make(map[int64]int64),
+		logger:           logger,
+	}
+}
+
+// NewMoveGovernorFromConfig returns nil when no limit is configured
+func NewMoveGovernorFromConfig(config *config.Config, logger Logger) *MoveGovernor {
+	gc := config.Partitioning.Governor
+	if gc.MinResidenceOps == 0 && gc.MinResidenceTime == 0 && gc.MaxMovesPerBlock == 0 {
+		return nil
+	}
+	return NewMoveGovernor(gc.MinResidenceOps, gc.MinResidenceTime*time.Second, gc.MaxMovesPerBlock, gc.OpsPerBlock, logger)
+}
+
+// Access counts an operation on each key
+func (g *MoveGovernor) Access(keys ...Key) {
+	if g == nil {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	for _, k := range keys {
+		if r, ok := g.residences[k]; ok {
+			r.ops++
+		}
+	}
+	g.ops++
+	if g.opsPerBlock > 0 && g.ops%g.opsPerBlock == 0 {
+		g.movesInBlock = make(map[int64]int64)
+	}
+}
+
+// NewBlock resets the moves emitted by partition
+func (g *MoveGovernor) NewBlock(partition int64) {
+	if g == nil {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	delete(g.movesInBlock, partition)
+}
+
+// check if k can leave partition from, should hold the lock
+func (g *MoveGovernor) check(k Key, from int64) int {
+	if r, ok := g.residences[k]; ok {
+		if r.ops < g.minResidenceOps || time.Since(r.since) < g.minResidenceTime {
+			return suppressedResidence
+		}
+	}
+	if g.maxMovesPerBlock > 0 && g.movesInBlock[from] >= g.maxMovesPerBlock {
+		return suppressedCap
+	}
+	return moveAllowed
+}
+
+func (g *MoveGovernor) suppress(reason int, k Key, from, to int64) {
+	reasonName := "residence"
+	if reason == suppressedCap {
+		g.counters.Cap++
+		reasonName = "cap"
+	} else {
+		g.counters.Residence++
+	}
+	if g.logger != nil {
+		g.logger.Log("suppressed-moves", "%d %v %v %d %d\n", time.Now().UnixNano(), reasonName, k, from, to)
+	}
+}
+
+// AllowMove reserves a move of k if the governor allows it
+func (g *MoveGovernor) AllowMove(k Key, from, to int64) bool {
+	if g == nil {
+		return true
+	}
+	g.Lock()
+	defer g.Unlock()
+	if reason := g.check(k, from); reason != moveAllowed {
+		g.suppress(reason, k, from, to)
+		return false
+	}
+	g.movesInBlock[from]++
+	return true
+}
+
+// Moved starts the residence of k in its new partition
+func (g *MoveGovernor) Moved(k Key) {
+	if g == nil {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	g.residences[k] = &residence{since: time.Now()}
+}
+
+func (g *MoveGovernor) Counters() GovernorCounters {
+	if g == nil {
+		return GovernorCounters{}
+	}
+	g.Lock()
+	defer g.Unlock()
+	return g.counters
+}
+
+// GovernedPartitioning steers WhereToMove away from destinations the governor
+// suppresses, preferring the choice of the wrapped partitioning
+type GovernedPartitioning struct {
+	Partitioning
+	governor *MoveGovernor
+}
+
+func NewGovernedPartitioning(partitioning Partitioning, governor *MoveGovernor) *GovernedPartitioning {
+	return &GovernedPartitioning{
+		Partitioning: partitioning,
+		governor:     governor,
+	}
+}
+
+// GetGovernor returns the governor of the partitioning, nil if not governed
+func GetGovernor(partitioning Partitioning) *MoveGovernor {
+	if gp, ok := partitioning.(*GovernedPartitioning); ok {
+		return gp.governor
+	}
+	return nil
+}
+
+func (gp *GovernedPartitioning) WhereToMove(keys ...Key) int64 {
+	gp.governor.Access(keys...)
+	moveToPartition := gp.Partitioning.WhereToMove(keys...)
+	partitions := make([]int64, len(keys))
+	for i, k := range keys {
+		partitions[i], _ = gp.Get(k)
+	}
+
+	g := gp.governor
+	g.Lock()
+	defer g.Unlock()
+	// Reason the keys cannot go to candidate
+	blocked := func(candidate int64) (int, int) {
+		for i, k := range keys {
+			if partitions[i] == candidate {
+				continue
+			}
+			if reason := g.check(k, partitions[i]); reason != moveAllowed {
+				return reason, i
+			}
+		}
+		return moveAllowed, 0
+	}
+	reserve := func(candidate int64) {
+		for _, partition := range partitions {
+			if partition != candidate {
+				g.movesInBlock[partition]++
+			}
+		}
+	}
+
+	reason, blockedKey := blocked(moveToPartition)
+	if reason == moveAllowed {
+		reserve(moveToPartition)
+		return moveToPartition
+	}
+	g.suppress(reason, keys[blockedKey], partitions[blockedKey], moveToPartition)
+	for _, candidate := range partitions {
+		if r, _ := blocked(candidate); r == moveAllowed {
+			reserve(candidate)
+			return candidate
+		}
+	}
+	// Objects must be co-located, move anyway
+	g.counters.Forced++
+	reserve(moveToPartition)
+	return moveToPartition
+}
+
+func (gp *GovernedPartitioning) Move(k Key, m int64) {
+	if partition, exists := gp.Get(k); exists && partition != m {
+		gp.governor.Moved(k)
+	}
+	gp.Partitioning.Move(k, m)
+}
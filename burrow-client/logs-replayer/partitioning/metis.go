@@ -0,0 +1,82 @@
+package partitioning
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MetisPartitioning places kitties as in an offline METIS partition file
+// (the output of gpmetis on the graph written by the multi-shard replayer).
+// Line i of the file has the partition, starting at 0, of kitty i+1.
+type MetisPartitioning struct {
+	*HashPartitioning
+	assignment map[Key]int64
+}
+
+func NewMetisPartitioning(nPartitions int64, placement Placement, partitionFile string) (*MetisPartitioning, error) {
+	file, err := os.Open(partitionFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	assignment := make(map[Key]int64)
+	scanner := bufio.NewScanner(file)
+	for id := int64(1); scanner.Scan(); id++ {
+		part, err := strconv.ParseInt(strings.TrimSpace(scanner.Text()), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing line %v of %v: %v", id, partitionFile, err)
+		}
+		if part < 0 || part >= nPartitions {
+			return nil, fmt.Errorf("Partition %v of kitty %v out of range, expected %v partitions", part, id, nPartitions)
+		}
+		// Partitions start at 1
+		assignment[id] = part + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &MetisPartitioning{
+		HashPartitioning: NewHashPartitioning(nPartitions, placement),
+		assignment:       assignment,
+	}, nil
+}
+
+// Add places k in its METIS partition, kitties not in the file use the placement
+func (mp *MetisPartitioning) Add(k Key) int64 {
+	mp.Lock()
+	defer mp.Unlock()
+	if partition, exists := mp.get(k); exists {
+		return partition
+	}
+	partition, ok := mp.assignment[k]
+	if !ok {
+		partition = mp.placement.Place(k)
+	}
+	mp.move(k, partition)
+	return partition
+}
+
+// WhereToMove prefers the partition METIS assigned to most of the keys
+func (mp *MetisPartitioning) WhereToMove(keys ...Key) int64 {
+	mp.RLock()
+	defer mp.RUnlock()
+	votes := make(map[int64]int)
+	for _, k := range keys {
+		if partition, ok := mp.assignment[k]; ok {
+			votes[partition]++
+		}
+	}
+	moveToPartition := mp.whereToMove(keys...)
+	for _, k := range keys {
+		partitionK, _ := mp.get(k)
+		if votes[partitionK] > votes[moveToPartition] {
+			moveToPartition = partitionK
+		}
+	}
+	return moveToPartition
+}
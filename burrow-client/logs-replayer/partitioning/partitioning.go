@@ -1,52 +1,76 @@
 package partitioning
 
 import (
+	"sync"
+
 	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/sirupsen/logrus"
 )
 
-// Partitions start at 1 to nPartitions inclusive
+// Key of a partitioned object, kitty ids (int64) in the replayers and
+// contract addresses (crypto.Address) in the ScalableCoin client
+type Key interface{}
+
+// Partitions start at 1 to nPartitions inclusive, implementations are thread-safe
 type Partitioning interface {
-	Add(k int64) int64         // Return partition added
-	Get(k int64) (int64, bool) // return partition and if key exists
-	IsSame(keys ...int64) bool
-	Move(k, m int64)
-	WhereToMove(keys ...int64) int64
+	Add(k Key) int64         // Return partition added
+	Get(k Key) (int64, bool) // return partition and if key exists
+	IsSame(keys ...Key) bool
+	Move(k Key, m int64)
+	WhereToMove(keys ...Key) int64
+	GetElementsInEachPart() map[int64]int64
+	GetObjects(partition int64) []Key
+	AddPartition() int64 // Add an empty partition, return its number
 }
 
+// HashPartitioning adds objects according to a Placement and moves them to
+// the least loaded partition among the ones involved
 type HashPartitioning struct {
-	nPartitions             int64
-	elementsInEachPartition map[int64]int64
-	partitionMap            map[int64]int64
+	nPartitions     int64
+	placement       Placement
+	partitionMap    map[Key]int64
+	partitionObjMap map[int64]map[Key]bool
+	sync.RWMutex
 }
 
-func NewHashPartitioning(nPartitions int64) *HashPartitioning {
-	return &HashPartitioning{
-		nPartitions:             nPartitions,
-		partitionMap:            make(map[int64]int64),
-		elementsInEachPartition: make(map[int64]int64),
+func NewHashPartitioning(nPartitions int64, placement Placement) *HashPartitioning {
+	hp := &HashPartitioning{
+		nPartitions:     nPartitions,
+		placement:       placement,
+		partitionMap:    make(map[Key]int64),
+		partitionObjMap: make(map[int64]map[Key]bool),
+	}
+	for i := int64(1); i <= nPartitions; i++ {
+		hp.partitionObjMap[i] = make(map[Key]bool)
 	}
+	return hp
 }
 
-func (hp *HashPartitioning) Add(k int64) int64 {
-	// Partitions start at 1
-	partition := k%hp.nPartitions + 1
-	hp.partitionMap[k] = partition
-	hp.elementsInEachPartition[partition]++
-	return partition
+// Number of objects in partition, should hold the lock
+func (hp *HashPartitioning) elements(partition int64) int64 {
+	return int64(len(hp.partitionObjMap[partition]))
 }
 
-func (hp *HashPartitioning) Get(k int64) (int64, bool) {
+func (hp *HashPartitioning) get(k Key) (int64, bool) {
 	v, ok := hp.partitionMap[k]
 	return v, ok
 }
 
-func (hp *HashPartitioning) IsSame(keys ...int64) bool {
-	part, exists := hp.Get(keys[0])
+func (hp *HashPartitioning) move(k Key, m int64) {
+	if originalPartition, exists := hp.get(k); exists {
+		delete(hp.partitionObjMap[originalPartition], k)
+	}
+	hp.partitionMap[k] = m
+	hp.partitionObjMap[m][k] = true
+}
+
+func (hp *HashPartitioning) isSame(keys ...Key) bool {
+	part, exists := hp.get(keys[0])
 	if !exists {
 		panic("Got key that doesn't exist")
 	}
 	for _, k := range keys[1:] {
-		p, exists := hp.Get(k)
+		p, exists := hp.get(k)
 		if !exists {
 			panic("Got key that doesn't exist")
 		}
@@ -57,40 +81,141 @@ func (hp *HashPartitioning) IsSame(keys ...int64) bool {
 	return true
 }
 
-func (hp *HashPartitioning) Move(k, m int64) {
-	originalPartition, exists := hp.Get(k)
-	if !exists {
-		hp.elementsInEachPartition[m]++
-	} else {
-		hp.elementsInEachPartition[originalPartition]--
-		hp.elementsInEachPartition[m]++
-	}
-	hp.partitionMap[k] = m
-}
-
-func (hp *HashPartitioning) WhereToMove(keys ...int64) int64 {
-	moveToPartition, exists := hp.Get(keys[0])
+func (hp *HashPartitioning) whereToMove(keys ...Key) int64 {
+	moveToPartition, exists := hp.get(keys[0])
 	if !exists {
-		moveToPartition = hp.Add(keys[0])
 		panic("Should exist")
 	}
 	for _, k := range keys[1:] {
-		partitionK, exists := hp.Get(k)
+		partitionK, exists := hp.get(k)
 		if !exists {
-			partitionK = hp.Add(k)
 			panic("Should exist!")
 		}
-		if hp.elementsInEachPartition[moveToPartition] > hp.elementsInEachPartition[partitionK] {
+		if hp.elements(moveToPartition) > hp.elements(partitionK) {
 			moveToPartition = partitionK
 		}
 	}
 	return moveToPartition
 }
 
-func GetPartitioning(config *config.Config) Partitioning {
+// Add places k, objects already placed (e.g. restored from a snapshot) stay
+func (hp *HashPartitioning) Add(k Key) int64 {
+	hp.Lock()
+	defer hp.Unlock()
+	if partition, exists := hp.get(k); exists {
+		return partition
+	}
+	partition := hp.placement.Place(k)
+	hp.move(k, partition)
+	return partition
+}
+
+func (hp *HashPartitioning) Get(k Key) (int64, bool) {
+	hp.RLock()
+	defer hp.RUnlock()
+	return hp.get(k)
+}
+
+func (hp *HashPartitioning) IsSame(keys ...Key) bool {
+	hp.RLock()
+	defer hp.RUnlock()
+	return hp.isSame(keys...)
+}
+
+func (hp *HashPartitioning) Move(k Key, m int64) {
+	hp.Lock()
+	defer hp.Unlock()
+	hp.move(k, m)
+}
+
+func (hp *HashPartitioning) WhereToMove(keys ...Key) int64 {
+	hp.RLock()
+	defer hp.RUnlock()
+	return hp.whereToMove(keys...)
+}
+
+func (hp *HashPartitioning) GetElementsInEachPart() map[int64]int64 {
+	hp.RLock()
+	defer hp.RUnlock()
+	elementsInEachPartition := make(map[int64]int64)
+	for p := range hp.partitionObjMap {
+		elementsInEachPartition[p] = hp.elements(p)
+	}
+	return elementsInEachPartition
+}
+
+func (hp *HashPartitioning) GetObjects(partition int64) []Key {
+	hp.RLock()
+	defer hp.RUnlock()
+	objects := make([]Key, 0, len(hp.partitionObjMap[partition]))
+	for k := range hp.partitionObjMap[partition] {
+		objects = append(objects, k)
+	}
+	return objects
+}
+
+// AddPartition scales out, new objects keep the initial placement and only
+// reach the new partition by moving
+func (hp *HashPartitioning) AddPartition() int64 {
+	hp.Lock()
+	defer hp.Unlock()
+	hp.nPartitions++
+	hp.partitionObjMap[hp.nPartitions] = make(map[Key]bool)
+	return hp.nPartitions
+}
+
+func (hp *HashPartitioning) GetMostUnbalanced() int64 {
+	hp.RLock()
+	defer hp.RUnlock()
+	mostUnbalancedIdx := int64(1)
+	for p := range hp.partitionObjMap {
+		if hp.elements(p) < hp.elements(mostUnbalancedIdx) {
+			mostUnbalancedIdx = p
+		}
+	}
+	return mostUnbalancedIdx
+}
+
+// GetPartitioning builds the configured partitioning, logger receives the
+// cost of the moves and the moves suppressed by the governor
+func GetPartitioning(config *config.Config, logger Logger) Partitioning {
 	var partitioning Partitioning
-	if config.Partitioning.Type == "hash" {
-		partitioning = NewHashPartitioning(config.Partitioning.NumberPartitions)
+	nPartitions := config.Partitioning.NumberPartitions
+	placement := NewPlacement(config.Partitioning.Placement, nPartitions, config.Partitioning.VirtualNodes, config.Partitioning.RangeMax)
+	switch config.Partitioning.Type {
+	case "hash", "":
+		partitioning = NewHashPartitioning(nPartitions, placement)
+	case "metis":
+		metisPartitioning, err := NewMetisPartitioning(nPartitions, placement, config.Partitioning.PartitionFile)
+		if err != nil {
+			logrus.Fatalf("Error: %v", err)
+		}
+		partitioning = metisPartitioning
+	case "coaccess":
+		partitioning = NewCoAccessPartitioning(nPartitions, placement, config.Partitioning.Decay, config.Partitioning.MaxImbalance)
+	default:
+		logrus.Fatalf("Unknown partitioning %v", config.Partitioning.Type)
+	}
+	if config.Partitioning.RestoreFile != "" {
+		err := LoadSnapshot(partitioning, config.Partitioning.RestoreFile, nPartitions)
+		if err != nil {
+			logrus.Fatalf("Error: %v", err)
+		}
+		logrus.Infof("Restored partitioning: %v", partitioning.GetElementsInEachPart())
+	}
+	if config.Partitioning.CostAware || config.Partitioning.CostFile != "" {
+		costModel := NewMeasuredCostModel()
+		if config.Partitioning.CostFile != "" {
+			var err error
+			costModel, err = NewFileCostModel(config.Partitioning.CostFile)
+			if err != nil {
+				logrus.Fatalf("Error: %v", err)
+			}
+		}
+		partitioning = NewCostAwarePartitioning(partitioning, costModel, logger)
+	}
+	if governor := NewMoveGovernorFromConfig(config, logger); governor != nil {
+		partitioning = NewGovernedPartitioning(partitioning, governor)
 	}
 	return partitioning
 }
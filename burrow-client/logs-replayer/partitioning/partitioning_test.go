@@ -0,0 +1,436 @@
+package partitioning
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/enriquefynn/sharding-runner/burrow-client/config"
+	"github.com/hyperledger/burrow/crypto"
+)
+
+func TestElementsAccounting(t *testing.T) {
+	hp := NewHashPartitioning(2, NewPlacement("modulo", 2, 0, 0))
+	hp.Add(int64(1))
+	hp.Add(int64(2))
+	hp.Add(int64(3))
+	hp.Move(int64(1), 1)
+	// Adding an existing key keeps it where it is
+	hp.Add(int64(3))
+
+	elements := hp.GetElementsInEachPart()
+	if elements[1] != 2 || elements[2] != 1 {
+		t.Errorf("Wrong elements in each partition: %v", elements)
+	}
+	if len(hp.GetObjects(1)) != 2 {
+		t.Errorf("Wrong objects in partition 1: %v", hp.GetObjects(1))
+	}
+	if hp.IsSame(int64(1), int64(3)) {
+		t.Errorf("1 and 3 should be in different partitions")
+	}
+	if p := hp.WhereToMove(int64(1), int64(3)); p != 2 {
+		t.Errorf("Should move to the least loaded partition, got %v", p)
+	}
+}
+
+func TestPlacementReproducible(t *testing.T) {
+	addr := crypto.MustAddressFromBytes([]byte("01234567890123456789"))
+	for _, placementType := range []string{"modulo", "keccak", "consistent", "range"} {
+		for _, nPartitions := range []int64{1, 4} {
+			p1 := NewPlacement(placementType, nPartitions, 0, 0)
+			p2 := NewPlacement(placementType, nPartitions, 0, 0)
+			for _, k := range []Key{addr, int64(42), int64(-1), int64(1 << 40)} {
+				part := p1.Place(k)
+				if part < 1 || part > nPartitions {
+					t.Errorf("%v: partition %v out of range", placementType, part)
+				}
+				if part != p2.Place(k) {
+					t.Errorf("%v: placement of %v not reproducible", placementType, k)
+				}
+			}
+		}
+	}
+
+	// Kitty IDs are split in contiguous ranges over all the partitions
+	rp := NewPlacement("range", 4, 0, 999)
+	for k, expected := range map[int64]int64{0: 1, 249: 1, 250: 2, 500: 3, 999: 4, 5000: 4} {
+		if part := rp.Place(k); part != expected {
+			t.Errorf("range: %v placed in %v, expected %v", k, part, expected)
+		}
+	}
+	count := make(map[int64]int)
+	rp = NewPlacement("range", 4, 0, 0)
+	for k := int64(0); k < defaultRangeMax; k += 1000 {
+		count[rp.Place(k)]++
+	}
+	for part := int64(1); part <= 4; part++ {
+		if count[part] < defaultRangeMax/1000/5 {
+			t.Errorf("range: only %v of the IDs in partition %v", count[part], part)
+		}
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "partitioning.snapshot")
+
+	addr := crypto.MustAddressFromBytes([]byte("01234567890123456789"))
+	hp := NewHashPartitioning(3, NewPlacement("modulo", 3, 0, 0))
+	hp.Add(int64(1))
+	hp.Add(int64(2))
+	hp.Add(addr)
+	hp.Move(int64(1), 3)
+	if err := SaveSnapshot(hp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewHashPartitioning(3, NewPlacement("modulo", 3, 0, 0))
+	if err := LoadSnapshot(restored, path, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []Key{int64(1), int64(2), addr} {
+		expected, _ := hp.Get(k)
+		if p, exists := restored.Get(k); !exists || p != expected {
+			t.Errorf("%v restored in %v, expected %v", k, p, expected)
+		}
+	}
+	// Restored objects are not placed again
+	if p := restored.Add(int64(1)); p != 3 {
+		t.Errorf("Add moved a restored object to %v", p)
+	}
+	if err := LoadSnapshot(NewHashPartitioning(2, NewPlacement("modulo", 2, 0, 0)), path, 2); err == nil {
+		t.Errorf("Loaded a snapshot with more partitions than configured")
+	}
+
+	corrupt := filepath.Join(dir, "corrupt.snapshot")
+	for _, partition := range []string{"0", "4"} {
+		contents := fmt.Sprintf("%v %d 3\nint64 1 %v\n", snapshotMagic, snapshotVersion, partition)
+		if err := ioutil.WriteFile(corrupt, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadSnapshot(NewHashPartitioning(3, NewPlacement("modulo", 3, 0, 0)), corrupt, 3); err == nil {
+			t.Errorf("Loaded a snapshot with an object in partition %v", partition)
+		}
+	}
+}
+
+func TestMeasuredCostModel(t *testing.T) {
+	var noModel *MeasuredCostModel
+	noModel.Measure(int64(1), 10, 5)
+	if cost, known := noModel.MoveCost(int64(1)); cost != 0 || known {
+		t.Errorf("Cost %v known %v without a model", cost, known)
+	}
+
+	dir, err := ioutil.TempDir("", "costs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "move-costs")
+	err = ioutil.WriteFile(path, []byte("1 10 5\n1 2 3\n2 20 5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileModel, err := NewFileCostModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	measured := NewMeasuredCostModel()
+	measured.Measure(int64(1), 10, 5)
+	measured.Measure(int64(1), 2, 3)
+	measured.Measure(int64(2), 20, 5)
+	for _, mc := range []*MeasuredCostModel{fileModel, measured} {
+		// The last cost is kept, unmeasured objects cost the average
+		for k, expected := range map[int64]int64{1: 5, 2: 25, 3: 15} {
+			cost, known := mc.MoveCost(k)
+			if cost != expected || known != (k != 3) {
+				t.Errorf("Cost of %v %v known %v, expected %v", k, cost, known, expected)
+			}
+		}
+	}
+	if cost, known := NewMeasuredCostModel().MoveCost(int64(1)); cost != 0 || known {
+		t.Errorf("Cost %v known %v without measures", cost, known)
+	}
+
+	err = ioutil.WriteFile(path, []byte("1 10\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileCostModel(path); err == nil {
+		t.Errorf("Malformed cost file loaded")
+	}
+}
+
+func TestCostAwareWhereToMove(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		costs    map[int64]int
+		expected int64
+	}{
+		// Decided by the wrapped partitioning, the least loaded partition
+		{"no costs", map[int64]int{}, 2},
+		{"same cost", map[int64]int{1: 10, 2: 10}, 2},
+		{"moving 1 is cheaper", map[int64]int{1: 5, 2: 30}, 2},
+		{"moving 2 is cheaper", map[int64]int{1: 30, 2: 5}, 1},
+		// 2 costs the average of 1 and 3
+		{"unmeasured", map[int64]int{1: 30, 3: 10}, 1},
+	} {
+		hp := NewHashPartitioning(2, NewPlacement("modulo", 2, 0, 0))
+		for k, partition := range map[int64]int64{1: 1, 2: 2, 3: 1} {
+			hp.Add(k)
+			hp.Move(k, partition)
+		}
+		mc := NewMeasuredCostModel()
+		for k, cost := range tc.costs {
+			mc.Measure(k, cost, 0)
+		}
+		cp := NewCostAwarePartitioning(hp, mc, nil)
+		if p := cp.WhereToMove(int64(1), int64(2)); p != tc.expected {
+			t.Errorf("%v: moved to %v, expected %v", tc.name, p, tc.expected)
+		}
+	}
+
+	// Cost-aware partitionings measured by the client, also when governed
+	var c config.Config
+	c.Partitioning.NumberPartitions = 2
+	if GetCostModel(GetPartitioning(&c, nil)) != nil {
+		t.Errorf("Cost model without costAware")
+	}
+	c.Partitioning.CostAware = true
+	if GetCostModel(GetPartitioning(&c, nil)) == nil {
+		t.Errorf("No cost model with costAware")
+	}
+	c.Partitioning.Governor.MaxMovesPerBlock = 1
+	if GetCostModel(GetPartitioning(&c, nil)) == nil {
+		t.Errorf("No cost model with costAware and a governor")
+	}
+}
+
+func TestMoveGovernor(t *testing.T) {
+	var noGovernor *MoveGovernor
+	if !noGovernor.AllowMove(int64(1), 1, 2) || noGovernor.Counters() != (GovernorCounters{}) {
+		t.Errorf("Move suppressed without a governor")
+	}
+
+	for _, tc := range []struct {
+		name             string
+		minResidenceOps  int64
+		minResidenceTime time.Duration
+		maxMovesPerBlock int64
+		opsPerBlock      int64
+		// Before moving object 1 from partition 1 to 2
+		before   func(g *MoveGovernor)
+		allowed  bool
+		counters GovernorCounters
+	}{
+		{"no limits", 0, 0, 0, 0, func(g *MoveGovernor) { g.Moved(int64(1)) }, true, GovernorCounters{}},
+		{"never moved", 2, time.Hour, 0, 0, func(g *MoveGovernor) {}, true, GovernorCounters{}},
+		{"residence ops", 2, 0, 0, 0, func(g *MoveGovernor) {
+			g.Moved(int64(1))
+			g.Access(int64(1))
+			g.Access(int64(2))
+		}, false, GovernorCounters{Residence: 1}},
+		{"residence ops reached", 2, 0, 0, 0, func(g *MoveGovernor) {
+			g.Moved(int64(1))
+			g.Access(int64(1))
+			g.Access(int64(1), int64(2))
+		}, true, GovernorCounters{}},
+		{"residence time", 0, time.Hour, 0, 0, func(g *MoveGovernor) { g.Moved(int64(1)) }, false, GovernorCounters{Residence: 1}},
+		{"residence time passed", 0, time.Millisecond, 0, 0, func(g *MoveGovernor) {
+			g.Moved(int64(1))
+			time.Sleep(2 * time.Millisecond)
+		}, true, GovernorCounters{}},
+		{"cap", 0, 0, 1, 0, func(g *MoveGovernor) { g.AllowMove(int64(2), 1, 2) }, false, GovernorCounters{Cap: 1}},
+		{"cap of another partition", 0, 0, 1, 0, func(g *MoveGovernor) { g.AllowMove(int64(2), 2, 1) }, true, GovernorCounters{}},
+		{"new block", 0, 0, 1, 0, func(g *MoveGovernor) {
+			g.AllowMove(int64(2), 1, 2)
+			g.NewBlock(1)
+		}, true, GovernorCounters{}},
+		{"block of ops", 0, 0, 1, 2, func(g *MoveGovernor) {
+			g.AllowMove(int64(2), 1, 2)
+			g.Access(int64(3))
+		}, false, GovernorCounters{Cap: 1}},
+		{"block of ops passed", 0, 0, 1, 2, func(g *MoveGovernor) {
+			g.AllowMove(int64(2), 1, 2)
+			g.Access(int64(3))
+			g.Access(int64(3))
+		}, true, GovernorCounters{}},
+	} {
+		g := NewMoveGovernor(tc.minResidenceOps, tc.minResidenceTime, tc.maxMovesPerBlock, tc.opsPerBlock, nil)
+		tc.before(g)
+		if allowed := g.AllowMove(int64(1), 1, 2); allowed != tc.allowed {
+			t.Errorf("%v: move allowed %v, expected %v", tc.name, allowed, tc.allowed)
+		}
+		if counters := g.Counters(); counters != tc.counters {
+			t.Errorf("%v: counters %+v, expected %+v", tc.name, counters, tc.counters)
+		}
+	}
+}
+
+func TestGovernedWhereToMove(t *testing.T) {
+	hp := NewHashPartitioning(2, NewPlacement("modulo", 2, 0, 0))
+	for k, partition := range map[int64]int64{1: 1, 2: 2, 3: 1} {
+		hp.Add(k)
+		hp.Move(k, partition)
+	}
+	g := NewMoveGovernor(0, time.Hour, 0, 0, nil)
+	gp := NewGovernedPartitioning(hp, g)
+	if GetGovernor(gp) != g || GetGovernor(hp) != nil {
+		t.Errorf("Wrong governor of the partitionings")
+	}
+	// To the least loaded partition
+	if p := gp.WhereToMove(int64(1), int64(2)); p != 2 {
+		t.Errorf("Moved to %v, expected 2", p)
+	}
+	// 1 has just moved, 2 moves instead
+	g.Moved(int64(1))
+	if p := gp.WhereToMove(int64(1), int64(2)); p != 1 {
+		t.Errorf("Moved to %v, expected 1", p)
+	}
+	if counters := g.Counters(); counters != (GovernorCounters{Residence: 1}) {
+		t.Errorf("Counters %+v after steering a move", counters)
+	}
+	// Both have just moved, they must be co-located anyway
+	gp.Move(int64(2), 1)
+	gp.Move(int64(2), 2)
+	if p := gp.WhereToMove(int64(1), int64(2)); p != 2 {
+		t.Errorf("Moved to %v, expected 2", p)
+	}
+	if counters := g.Counters(); counters != (GovernorCounters{Residence: 2, Forced: 1}) {
+		t.Errorf("Counters %+v after a forced move", counters)
+	}
+}
+
+func TestCoAccessDecay(t *testing.T) {
+	cp := NewCoAccessPartitioning(2, NewPlacement("modulo", 2, 0, 0), 0.5, 0)
+	if cp.maxImbalance != defaultMaxImbalance {
+		t.Errorf("maxImbalance %v, expected the default", cp.maxImbalance)
+	}
+	cp.record(int64(1), int64(2))
+	cp.record(int64(1), int64(2))
+	// 1 decayed once plus 1
+	if value := cp.decayed(cp.coAccess[int64(1)][int64(2)]); value != 1.5 {
+		t.Errorf("Co-access %v, expected 1.5", value)
+	}
+	cp.record(int64(3), int64(4))
+	cp.record(int64(3), int64(4))
+	if value := cp.decayed(cp.coAccess[int64(2)][int64(1)]); value != 0.375 {
+		t.Errorf("Co-access %v after 2 decisions, expected 0.375", value)
+	}
+	// Forgotten once below minCoAccess
+	for i := 0; i < 10; i++ {
+		cp.record(int64(3), int64(4))
+	}
+	cp.Add(int64(2))
+	cp.affinity(int64(1), nil)
+	if _, ok := cp.coAccess[int64(1)][int64(2)]; ok {
+		t.Errorf("Decayed co-access not forgotten")
+	}
+
+	// Without decay counters add up
+	cp = NewCoAccessPartitioning(2, NewPlacement("modulo", 2, 0, 0), 0, 0)
+	for i := 0; i < 3; i++ {
+		cp.record(int64(1), int64(2))
+	}
+	if value := cp.decayed(cp.coAccess[int64(1)][int64(2)]); value != 3 {
+		t.Errorf("Co-access %v without decay, expected 3", value)
+	}
+}
+
+func TestCoAccessWhereToMove(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// Objects accessed together before, once per pair
+		history  [][2]int64
+		extra    []int64
+		expected int64
+	}{
+		// Ties go to the partition of the first object
+		{"no history", nil, nil, 1},
+		{"1 with partition 2", [][2]int64{{1, 4}, {1, 5}}, nil, 2},
+		{"6 with partition 1", [][2]int64{{1, 4}, {6, 2}, {6, 3}}, nil, 1},
+		// Partition 2 would be 2 objects over the average
+		{"partition 2 full", [][2]int64{{1, 4}, {1, 5}}, []int64{8, 10}, 1},
+	} {
+		// 1, 2, 3 in partition 1 and 4, 5, 6 in partition 2
+		cp := NewCoAccessPartitioning(2, NewPlacement("modulo", 2, 0, 0), 0, 0)
+		for k, partition := range map[int64]int64{1: 1, 2: 1, 3: 1, 4: 2, 5: 2, 6: 2} {
+			cp.Add(k)
+			cp.Move(k, partition)
+		}
+		for _, k := range tc.extra {
+			cp.Add(k)
+			cp.Move(k, 2)
+		}
+		for _, pair := range tc.history {
+			cp.record(pair[0], pair[1])
+		}
+		if p := cp.WhereToMove(int64(1), int64(6)); p != tc.expected {
+			t.Errorf("%v: moved to %v, expected %v", tc.name, p, tc.expected)
+		}
+	}
+}
+
+func TestMetisPartitioning(t *testing.T) {
+	placement := NewPlacement("modulo", 2, 0, 0)
+	// Kitties 1, 4 and 6 in METIS partition 0, 2, 3 and 5 in 1
+	mp, err := NewMetisPartitioning(2, placement, filepath.Join("testdata", "kitties.graph.part.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, expected := range map[int64]int64{1: 1, 2: 2, 3: 2, 4: 1, 5: 2, 6: 1, 7: placement.Place(int64(7)), 8: placement.Place(int64(8))} {
+		if partition := mp.Add(k); partition != expected {
+			t.Errorf("Kitty %v added to %v, expected %v", k, partition, expected)
+		}
+	}
+	// Where they are now: 2, 4, 5, 6 and 8 in partition 1, 1, 3 and 7 in partition 2
+	mp.Move(int64(1), 2)
+	mp.Move(int64(5), 1)
+	mp.Move(int64(2), 1)
+	for _, tc := range []struct {
+		name     string
+		keys     []Key
+		expected int64
+	}{
+		{"agreeing votes", []Key{int64(1), int64(4)}, 1},
+		{"split votes", []Key{int64(3), int64(4)}, 2},
+		{"kitties not in the file", []Key{int64(7), int64(8)}, 2},
+	} {
+		if p := mp.WhereToMove(tc.keys...); p != tc.expected {
+			t.Errorf("%v: moved to %v, expected %v", tc.name, p, tc.expected)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "metis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"out of range", "0\n2\n", "Partition 2 of kitty 2 out of range"},
+		{"negative", "-1\n", "Partition -1 of kitty 1 out of range"},
+		{"not a number", "0\n1\nx\n", "Error parsing line 3"},
+	} {
+		path := filepath.Join(dir, "graph.part.2")
+		err := ioutil.WriteFile(path, []byte(tc.file), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewMetisPartitioning(2, placement, path)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: error %v, expected %v", tc.name, err, tc.err)
+		}
+	}
+}
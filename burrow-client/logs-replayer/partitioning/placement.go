@@ -0,0 +1,163 @@
+package partitioning
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+// Placement decides the initial partition (starting at 1) of an object
+type Placement interface {
+	Place(k Key) int64
+}
+
+const defaultVirtualNodes = 64
+
+// Largest kitty ID of the replayed logs
+const defaultRangeMax = 2000000
+
+// NewPlacement returns a placement by name, modulo is the default. rangeMax is
+// the largest int key split by range (0 for the default).
+func NewPlacement(placementType string, nPartitions int64, virtualNodes int, rangeMax int64) Placement {
+	switch placementType {
+	case "modulo", "":
+		return &ModuloPlacement{nPartitions: nPartitions}
+	case "roundRobin":
+		return &RoundRobinPlacement{nPartitions: nPartitions}
+	case "keccak":
+		return &KeccakPlacement{nPartitions: nPartitions}
+	case "consistent":
+		if virtualNodes <= 0 {
+			virtualNodes = defaultVirtualNodes
+		}
+		return NewConsistentHashPlacement(nPartitions, virtualNodes)
+	case "range":
+		if rangeMax <= 0 {
+			rangeMax = defaultRangeMax
+		}
+		return &RangePlacement{nPartitions: nPartitions, rangeMax: rangeMax}
+	}
+	logrus.Fatalf("Unknown placement %v", placementType)
+	return nil
+}
+
+// keyBytes is the big endian representation of int keys or the bytes of addresses
+func keyBytes(k Key) []byte {
+	switch key := k.(type) {
+	case int64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(key))
+		return b
+	case interface{ Bytes() []byte }:
+		return key.Bytes()
+	case string:
+		return []byte(key)
+	}
+	panic(fmt.Sprintf("Unsupported key type %T", k))
+}
+
+// Last 8 bytes of the key
+func keyUint64(k Key) uint64 {
+	b := keyBytes(k)
+	return binary.BigEndian.Uint64(b[len(b)-8:])
+}
+
+// ModuloPlacement is the key (or its last 8 bytes) modulo the number of partitions
+type ModuloPlacement struct {
+	nPartitions int64
+}
+
+func (mp *ModuloPlacement) Place(k Key) int64 {
+	return int64(keyUint64(k)%uint64(mp.nPartitions)) + 1
+}
+
+// RoundRobinPlacement ignores the key, not reproducible across runs
+type RoundRobinPlacement struct {
+	nPartitions   int64
+	nextPartition int64
+	sync.Mutex
+}
+
+func (rr *RoundRobinPlacement) Place(k Key) int64 {
+	rr.Lock()
+	defer rr.Unlock()
+	rr.nextPartition = (rr.nextPartition + 1) % rr.nPartitions
+	return rr.nextPartition + 1
+}
+
+func keccakUint64(data []byte) uint64 {
+	return binary.BigEndian.Uint64(ethcrypto.Keccak256(data)[:8])
+}
+
+// KeccakPlacement hashes the key modulo the number of partitions
+type KeccakPlacement struct {
+	nPartitions int64
+}
+
+func (kp *KeccakPlacement) Place(k Key) int64 {
+	return int64(keccakUint64(keyBytes(k))%uint64(kp.nPartitions)) + 1
+}
+
+type virtualNode struct {
+	hash      uint64
+	partition int64
+}
+
+// ConsistentHashPlacement maps the key to the next virtual node in a hash ring
+type ConsistentHashPlacement struct {
+	ring []virtualNode
+}
+
+func NewConsistentHashPlacement(nPartitions int64, virtualNodes int) *ConsistentHashPlacement {
+	ch := &ConsistentHashPlacement{}
+	for partition := int64(1); partition <= nPartitions; partition++ {
+		for vn := 0; vn < virtualNodes; vn++ {
+			ch.ring = append(ch.ring, virtualNode{
+				hash:      keccakUint64([]byte(fmt.Sprintf("%d-%d", partition, vn))),
+				partition: partition,
+			})
+		}
+	}
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i].hash < ch.ring[j].hash })
+	return ch
+}
+
+func (ch *ConsistentHashPlacement) Place(k Key) int64 {
+	hash := keccakUint64(keyBytes(k))
+	idx := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i].hash >= hash })
+	if idx == len(ch.ring) {
+		idx = 0
+	}
+	return ch.ring[idx].partition
+}
+
+// RangePlacement splits the key space in equal contiguous ranges: int keys in
+// [0, rangeMax], larger ones in the last range, and the first 8 bytes of the
+// other keys
+type RangePlacement struct {
+	nPartitions int64
+	rangeMax    int64
+}
+
+func (rp *RangePlacement) Place(k Key) int64 {
+	if rp.nPartitions == 1 {
+		return 1
+	}
+	if key, ok := k.(int64); ok {
+		if key < 0 {
+			return 1
+		}
+		partition := key/(rp.rangeMax/rp.nPartitions+1) + 1
+		if partition > rp.nPartitions {
+			partition = rp.nPartitions
+		}
+		return partition
+	}
+	rangeSize := math.MaxUint64/uint64(rp.nPartitions) + 1
+	return int64(binary.BigEndian.Uint64(keyBytes(k)[:8])/rangeSize) + 1
+}
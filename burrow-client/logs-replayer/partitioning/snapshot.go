@@ -0,0 +1,113 @@
+package partitioning
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/burrow/crypto"
+)
+
+// Snapshot files start with a header line: partitioning-snapshot <version> <nPartitions>
+// followed by one line per object: <keyType> <key> <partition>
+const (
+	snapshotMagic   = "partitioning-snapshot"
+	snapshotVersion = 1
+)
+
+func encodeKey(k Key) (string, string, error) {
+	switch key := k.(type) {
+	case int64:
+		return "int64", strconv.FormatInt(key, 10), nil
+	case crypto.Address:
+		return "address", hex.EncodeToString(key.Bytes()), nil
+	}
+	return "", "", fmt.Errorf("Cannot snapshot key type %T", k)
+}
+
+func decodeKey(keyType, key string) (Key, error) {
+	switch keyType {
+	case "int64":
+		return strconv.ParseInt(key, 10, 64)
+	case "address":
+		b, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, err
+		}
+		return crypto.AddressFromBytes(b)
+	}
+	return nil, fmt.Errorf("Unknown key type %v", keyType)
+}
+
+// SaveSnapshot writes where every object of partitioning is
+func SaveSnapshot(partitioning Partitioning, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	elementsInEachPart := partitioning.GetElementsInEachPart()
+	fmt.Fprintf(writer, "%v %d %d\n", snapshotMagic, snapshotVersion, len(elementsInEachPart))
+	for partition := int64(1); partition <= int64(len(elementsInEachPart)); partition++ {
+		for _, k := range partitioning.GetObjects(partition) {
+			keyType, key, err := encodeKey(k)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(writer, "%v %v %d\n", keyType, key, partition)
+		}
+	}
+	return writer.Flush()
+}
+
+// LoadSnapshot moves every object of the snapshot to its partition
+func LoadSnapshot(partitioning Partitioning, path string, nPartitions int64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return fmt.Errorf("Empty snapshot %v", path)
+	}
+	var magic string
+	var version, snapshotPartitions int64
+	_, err = fmt.Sscanf(scanner.Text(), "%s %d %d", &magic, &version, &snapshotPartitions)
+	if err != nil || magic != snapshotMagic {
+		return fmt.Errorf("%v is not a partitioning snapshot", path)
+	}
+	if version != snapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %v, expected %v", version, snapshotVersion)
+	}
+	if snapshotPartitions > nPartitions {
+		return fmt.Errorf("Snapshot has %v partitions, configured %v", snapshotPartitions, nPartitions)
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			return fmt.Errorf("Malformed line %v in %v", line, path)
+		}
+		k, err := decodeKey(fields[0], fields[1])
+		if err != nil {
+			return err
+		}
+		partition, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		// Would not be in any partition
+		if partition < 1 || partition > snapshotPartitions {
+			return fmt.Errorf("Partition %v out of range at line %v in %v", partition, line, path)
+		}
+		partitioning.Move(k, partition)
+	}
+	return scanner.Err()
+}
@@ -106,7 +106,6 @@ func (lat *Latencies) Remove(txHash string, tx *dependencies.TxResponse, log *Lo
 			initialTime = timeMoved2
 		}
 	}
-	if tx.MethodName != "moveTo" || tx.MethodName != "move2" {
-		log.Log("latencies", "%v %d %d %v\n", tx.MethodName, initialTime, finalTime, requiredMove)
-	}
+	// moveTo and move2 are logged too, as their own latencies
+	log.Log("latencies", "%v %d %d %v\n", tx.MethodName, initialTime, finalTime, requiredMove)
 }
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/burrow/crypto"
+	"github.com/hyperledger/burrow/deploy/def"
+	"github.com/hyperledger/burrow/rpc/rpcquery"
+)
+
+func encodedSize(v interface{}) int {
+	if sizer, ok := v.(interface{ Size() int }); ok {
+		return sizer.Size()
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(encoded)
+}
+
+// ProofSizes returns the size of the storage proof, which carries the contract
+// storage to the destination shard, and the size of the account proof
+func ProofSizes(storageProof, accountProof interface{}) (int, int) {
+	return encodedSize(storageProof), encodedSize(accountProof)
+}
+
+// ProofsAtOneHeight fetches the proofs of a batch of moved contracts. A block
+// committed in between fetches them all again, at most tries times, so one
+// signed header serves the whole batch. Returns the proofs and their heights.
+func ProofsAtOneHeight(client *def.Client, addresses []crypto.Address, tries int) ([]*rpcquery.AccountProofs, map[int64]bool, error) {
+	var proofs []*rpcquery.AccountProofs
+	var heights map[int64]bool
+	for try := 0; try < tries; try++ {
+		proofs = proofs[:0]
+		heights = make(map[int64]bool)
+		for _, address := range addresses {
+			proof, err := client.GetAccountProof(address)
+			if err != nil {
+				return nil, nil, err
+			}
+			proofs = append(proofs, proof)
+			heights[proof.StorageProof.Version] = true
+		}
+		if len(heights) <= 1 {
+			break
+		}
+	}
+	return proofs, heights, nil
+}
@@ -90,6 +90,31 @@ func ListenBlockHeaders2(partition string, client *def.Client, logs *Log, blockC
 	}
 }
 
+// FetchSignedHeader gets the signed header of the block at height
+func FetchSignedHeader(client *def.Client, height int64) (*rpcevents.SignedHeadersResult, error) {
+	request := &rpcevents.BlocksRequest{
+		BlockRange: rpcevents.NewBlockRange(rpcevents.AbsoluteBound(uint64(height)), rpcevents.AbsoluteBound(uint64(height))),
+	}
+	clientEvents, err := client.Events(logging.NewNoopLogger())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signedHeaders, err := clientEvents.StreamSignedHeaders(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := signedHeaders.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if resp.SignedHeader.Height != height {
+		return nil, fmt.Errorf("Got header %v instead of %v", resp.SignedHeader.Height, height)
+	}
+	return resp, nil
+}
+
 func CreateContract(chainID string, config *config.Config, accounts *logsreader.LogsReader, client *def.Client, path string, args ...interface{}) (*crypto.Address, error) {
 	contractEnv, err := accounts.CreateContract(chainID, path, args...)
 	if err != nil {
@@ -6,6 +6,7 @@ require (
 	github.com/ethereum/go-ethereum v1.8.27
 	github.com/hyperledger/burrow v1.0.0
 	github.com/sirupsen/logrus v1.4.2
+	github.com/tendermint/tendermint v0.31.5
 	google.golang.org/appengine v1.4.0 // indirect
 	gopkg.in/yaml.v2 v2.2.2
 )